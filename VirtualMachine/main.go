@@ -35,6 +35,9 @@ var files	[]SourceFile
 var linkerMode LinkerMode
 var loadAddress MachineAddress
 var executableProvided bool = false
+var providedImage *dubcc.Image // -e com uma imagem .hex/.mem em vez de um executável
+var entryPoint MachineAddress
+var saveListing bool
 var window *app.Window
 var editor EditorApp
 var th *material.Theme
@@ -97,23 +100,33 @@ func main() {
 						continue
 					}
 					r := bytes.NewReader(obj)
-					executable, err := assembler.Read(r)
-					if err != nil {
-						panic(err.Error())
+					var executable *assembler.ObjectFile
+					if format, ok := dubcc.ImageFormatOf(os.Args[i+1]); ok {
+						img, err := dubcc.ReadImage(r, format)
+						if err != nil {
+							log.Fatalf("error: %s: %v", os.Args[i+1], err)
+						}
+						providedImage = &img
+					} else {
+						executable, err = assembler.Read(r)
+						if err != nil {
+							log.Fatalf("error: %s: %v", os.Args[i+1], err)
+						}
 					}
 					file := SourceFile{
-						Name: string(obj),
+						Name: os.Args[i+1],
 						Data: "",
 						Object: executable,
 					}
 					files = append(files, file)
 					executableProvided = true
+					i++
 					continue
 				} else {
 					log.Fatal("error: --executable needs <executabe path>")
 				}
 			case "-l", "--lst":
-				log.Fatal("not implemented xd")
+				saveListing = true
 			case "-a", "--absolute":
 				if len(os.Args) != i+1 {
 					log.Fatal("usage: --absolute <load address>")
@@ -124,6 +137,7 @@ func main() {
 					if err != nil {
 						log.Fatal("error: " + err.Error())
 					}
+					i++
 					continue
 				} 
 			case "-s", "--save-temps":
@@ -11,7 +11,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"slices"
 	"strings"
 	"time"
 	"gioui.org/font"
@@ -371,7 +370,7 @@ func actionButtonsLayout(gtx layout.Context, th *material.Theme) layout.Dimensio
 			layout.Spacer{Width: unit.Dp(8)}.Layout,
 		),
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-			if sim.State != dubcc.SimStateHalt {
+			if sim.State != dubcc.SimStateHalt && sim.State != dubcc.SimStateFault {
 				if stepBtn.Clicked(gtx) {
 					StepSimulation()
 				}
@@ -380,9 +379,9 @@ func actionButtonsLayout(gtx layout.Context, th *material.Theme) layout.Dimensio
 				if resetBtn.Clicked(gtx) {
 					log.Printf("reset!")
 					sim.State = dubcc.SimStateRun
+					sim.Fault = nil
 					sim.Registers = dubcc.StartupRegisters(&sim.Isa, dubcc.MachineAddress(len(sim.Mem.Work)))
-					startAddressMachineWord := dubcc.MachineWord(assemblerSingleton.StartAddress)
-					sim.SetRegister(dubcc.RegPC, startAddressMachineWord)
+					sim.SetRegister(dubcc.RegPC, dubcc.MachineWord(entryPoint))
 					terminal.Clear()
 					WipeMemory()
 				}
@@ -427,9 +426,9 @@ func CompileCode() {
 	print(files)
 	// we definetely should make this function smaller
 	sim.Registers = dubcc.StartupRegisters(&sim.Isa, dubcc.MachineAddress(len(sim.Mem.Work)))
-	var assemblers = make([]assembler.Info, len(files))
 	var linkerSingleton *Linker
 	var objects []*assembler.ObjectFile
+	var diags dubcc.Diagnostics
 
 	if executableProvided {
 		goto populateMemory
@@ -439,21 +438,16 @@ func CompileCode() {
 	case Relocator:
 		linkerSingleton = linker.MakeRelocatorLinker()
 	case Absolute:
-		linkerSingleton = linker.MakeAbsoluteLinker(0)
+		linkerSingleton = linker.MakeAbsoluteLinker(loadAddress)
 	}
 
 	for i := range files {
 		macroProcessor := macroprocessor.MakeMacroProcessor(0)
-		expanded := []string{}
-		for _, line := range strings.Split(files[i].Data, "\n") {
-			lines, err := macroProcessor.ProcessLine(line)
-			if err != nil {
-				log.Print(err)
-			}
-			expanded = append(expanded, lines...)
-		}
+		expanded, macroDiags := macroProcessor.ExpandSource(files[i].Name, files[i].Data)
+		diags = append(diags, macroDiags...)
 
 		asm := assembler.MakeAssembler()
+		asm.SetFile(files[i].Name)
 		{
 			// I believe this should be generated after the linking etc
 			fname := files[i].Name
@@ -468,26 +462,29 @@ func CompileCode() {
 				defer masmaprg.Close()
 			}
 			for _, line := range expanded {
-				masmaprg.WriteString(line + "\n")
-				asm.FirstPassString(line)
+				masmaprg.WriteString(line.Text + "\n")
+				asm.FirstPassSource(line)
 			}
 		}
 
 		println(macroProcessor.MacroReport())
 
 		_, err := asm.SecondPass()
+		diags = append(diags, asm.Diagnostics()...)
+		if saveListing || sim.SaveTemps {
+			lstFilename := tempBaseName(files[i].Name) + ".lst"
+			if err := assembler.SaveListingFile(&asm, lstFilename); err != nil {
+				log.Printf("warning: could not save %s: %v", lstFilename, err)
+			}
+		}
 		if err != nil {
-			err = fmt.Errorf("error: could not compile: %v", err)
-			terminal.Write(err.Error())
-			return
+			continue
 		}
-		assemblers = append(assemblers, asm)
 
 		obj, err := asm.GenerateObjectFile()
 		if err != nil {
-			err = fmt.Errorf("error: could not compile: %v", err)
-			terminal.Write(err.Error())
-			return
+			diags.Errorf(files[i].Name, 0, 0, "could not generate object file: %v", err)
+			continue
 		}
 		pp.Print(obj)
 
@@ -496,109 +493,94 @@ func CompileCode() {
 			len(obj.Symbols), len(obj.Relocations))
 
 		if sim.SaveTemps {
-			path := files[i].Name
-			base := filepath.Base(path)
-			if dot := strings.LastIndex(base, "."); dot != -1 {
-				base = base[:dot]
-			}
-			objFilename := base + ".o"
+			objFilename := tempBaseName(files[i].Name) + ".o"
 			if err := assembler.SaveCompleteObjectFile(obj, objFilename); err != nil {
 				log.Printf("warning: could not save %s: %v", objFilename, err)
 			}
 		}
 	}
 
+	for _, diag := range diags {
+		terminal.Write(diag.Error() + "\n")
+	}
+	if diags.HasErrors() {
+		terminal.Write("error: could not compile\n")
+		return
+	}
+
 	for i := range files {
 		objects = append(objects, files[i].Object)
+		linkerSingleton.ObjectNames = append(linkerSingleton.ObjectNames, files[i].Name)
 	}
 
 populateMemory:
 	var executable *ObjectFile
 
+	if providedImage != nil {
+		loadImage(*providedImage)
+		return
+	}
 	if executableProvided {
 		executable = files[0].Object
 	} else {
 		var err error
 		executable, err = linkerSingleton.GenerateExecutable(objects)
 		if err != nil {
-			log.Printf("error: could not generate an executable\n%s\n", err.Error())
-		}
-	}
-
-	mem := []dubcc.MachineWord{}
-
-	for _, section := range executable.Sections {
-		addr := section.Header.Address
-		tail := len(mem)
-		if tail < int(addr) {
-			mem = slices.Grow(mem, int(addr)-tail)
-		}
-		if int(addr) < tail {
-			panic("section overlap")
+			for _, diag := range linkerSingleton.Diags {
+				terminal.Write(diag.Error() + "\n")
+			}
+			terminal.Write("error: could not generate an executable\n")
+			return
 		}
-		mem = append(mem, section.Data...)
 	}
 
 	print(executable.PrettyPrint())
 
-	if len(mem) > len(sim.Mem.Work) {
-		panic("program's too big")
-	}
-
-	startAddressMachineWord := dubcc.MachineWord(assemblers[0].StartAddress)
-	sim.SetRegister(dubcc.RegPC, startAddressMachineWord) // Altera o valor do PC pro valor indicado na diretiva "start"
-	startAddressInt := int(assemblers[0].StartAddress)
-
 	// NOTE: loader starts here
-	for idx, val := range mem {
-		sim.Mem.Work[startAddressInt+idx] = val
+	loadImage(executable.Image())
+}
+
+func loadImage(img dubcc.Image) {
+	if err := sim.LoadImage(img); err != nil {
+		terminal.Write(fmt.Sprintf("error: could not load the executable: %v\n", err))
+		return
 	}
+	entryPoint = img.Entry // PC começa no ponto de entrada do cabeçalho
 	sim.State = dubcc.SimStatePause
+	sim.Fault = nil
+}
+
+// Name (without extension) used for files saved next to the program
+func tempBaseName(path string) string {
+	base := filepath.Base(path)
+	if dot := strings.LastIndex(base, "."); dot != -1 {
+		base = base[:dot]
+	}
+	return base
 }
 
 func StepSimulation() {
-	pc := sim.GetRegister(dubcc.RegPC)
-	instWord := sim.Mem.Work[pc]
-	sim.SetRegister(dubcc.RegRI, instWord)
-	inst, ifound := sim.InstructionFromWord(instWord)
-	if !ifound {
-		log.Printf("invalid instruction %x (%d)\n", instWord, instWord)
-	} else {
-		handler, hfound := sim.Handlers[inst.Repr]
-		if !hfound {
-			log.Printf("couldn't handle instruction %v\n", inst)
-		}
-		instPos := dubcc.MachineAddress(pc)
-		argsTerm := instPos + 1 + dubcc.MachineAddress(inst.NumArgs)
-		// set pc before calling the handler
-		// that way branching works
-		oldPc := sim.GetRegister(dubcc.RegPC)
-		nextPc := (pc + dubcc.MachineWord(1+inst.NumArgs)) % dubcc.MachineWord(len(sim.Mem.Work))
-		sim.MapRegister(
-			dubcc.RegPC,
-			func(pc dubcc.MachineWord) dubcc.MachineWord {
-				return nextPc
-			},
-		)
-		if nextPc < dubcc.MachineWord(instPos) {
-			log.Printf("pc wrapped around! halt.")
-			sim.State = dubcc.SimStateHalt
-			return
-		}
-		args := sim.Mem.Work[instPos:argsTerm]
-		log.Printf("Executing %s with %v", inst.Name, args)
-		handler(&sim, args)
-		switch sim.State {
-		case dubcc.SimStateRun:
+	result := sim.Step()
+	switch result.Kind {
+	case dubcc.StepExecuted:
+		log.Printf("Executing %s with %v", result.Inst.Name, result.Args)
+		if sim.State == dubcc.SimStateRun {
 			sim.State = dubcc.SimStatePause
-		case dubcc.SimStateIOBlocked:
-			sim.SetRegister(dubcc.RegPC, oldPc) // actually block
 		}
-
-		if len(sim.OutWords) > 0 {
-			terminal.Write(string(sim.RxOutWord()))
+	case dubcc.StepIOBlocked:
+		log.Printf("%s is waiting for input", result.Inst.Name)
+	case dubcc.StepFault:
+		log.Printf("machine fault: %v", result.Err)
+		terminal.Write(fmt.Sprintf("\nmachine fault: %v\n", result.Err))
+		if loopTimer != nil {
+			loopTimer.Stop()
+			loopTimer = nil
 		}
 	}
+
+	for len(sim.OutWords) > 0 {
+		terminal.Write(string(rune(sim.RxOutWord())))
+	}
 }
 
 func WipeMemory() {
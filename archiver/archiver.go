@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"dubcc/assembler"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const usage = `usage: dubar <command> <archive.a> [file ...]
+
+Bundles DULF objects into a static library. The linker takes archives
+next to objects and only pulls in the members that resolve undefined
+symbols.
+
+commands:
+  c, create    create archive.a from the given objects, replacing it
+  t, list      list the members and the global symbols each one defines
+  x, extract   write the given members (all if none) to the current directory
+`
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+)
+
+func main() {
+	args := os.Args[1:]
+	if len(args) == 1 && (args[0] == "-h" || args[0] == "--help") {
+		fmt.Print(usage)
+		os.Exit(exitOK)
+	}
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "dubar: missing command or archive\n%s", usage)
+		os.Exit(exitUsage)
+	}
+
+	var err error
+	switch command, archive, files := args[0], args[1], args[2:]; command {
+	case "c", "create":
+		err = create(archive, files)
+	case "t", "list":
+		err = list(archive)
+	case "x", "extract":
+		err = extract(archive, files)
+	default:
+		fmt.Fprintf(os.Stderr, "dubar: unknown command %s\n%s", command, usage)
+		os.Exit(exitUsage)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dubar: %v\n", err)
+		os.Exit(exitError)
+	}
+}
+
+func create(archive string, files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no objects to put in %s", archive)
+	}
+	var members []assembler.ArchiveMember
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		obj, err := assembler.Read(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		name := filepath.Base(file)
+		if slices.ContainsFunc(members, func(m assembler.ArchiveMember) bool { return m.Name == name }) {
+			return fmt.Errorf("two members named %s", name)
+		}
+		members = append(members, assembler.ArchiveMember{Name: name, Object: obj})
+	}
+
+	ar, err := assembler.MakeArchive(members)
+	if err != nil {
+		return fmt.Errorf("%s: %v", archive, err)
+	}
+	return assembler.SaveArchive(ar, archive)
+}
+
+func readArchive(archive string) (*assembler.Archive, error) {
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		return nil, err
+	}
+	ar, err := assembler.ReadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", archive, err)
+	}
+	return ar, nil
+}
+
+func list(archive string) error {
+	ar, err := readArchive(archive)
+	if err != nil {
+		return err
+	}
+	for idx, member := range ar.Members {
+		var symbols []string
+		for name, memberIdx := range ar.Index {
+			if memberIdx == idx {
+				symbols = append(symbols, name)
+			}
+		}
+		slices.Sort(symbols)
+		fmt.Printf("%s: %s\n", member.Name, strings.Join(symbols, " "))
+	}
+	return nil
+}
+
+func extract(archive string, names []string) error {
+	ar, err := readArchive(archive)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(ar.Members, func(m assembler.ArchiveMember) bool { return m.Name == name }) {
+			return fmt.Errorf("%s has no member %s", archive, name)
+		}
+	}
+	for _, member := range ar.Members {
+		if len(names) > 0 && !slices.Contains(names, member.Name) {
+			continue
+		}
+		if err := assembler.SaveCompleteObjectFile(member.Object, filepath.Base(member.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
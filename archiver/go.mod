@@ -0,0 +1,15 @@
+module dubcc/dubar
+
+go 1.24.3
+
+replace dubcc => ../shared/
+
+require dubcc v0.0.0-00010101000000-000000000000
+
+require (
+	github.com/k0kubun/pp/v3 v3.4.1 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.16 // indirect
+	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/text v0.19.0 // indirect
+)
@@ -1,55 +1,219 @@
 package main
 
 import (
-	"dubcc"
 	"bufio"
-	"bytes"
+	"dubcc"
 	assembler "dubcc/assembler"
-	"github.com/k0kubun/pp/v3"
+	"dubcc/macroprocessor"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+const usage = `usage: dubasm [options] [file.asm ...]
+
+Runs the macroprocessor and the assembler over each file and writes a
+DULF object (file.o). Reads stdin (and writes stdout) when no file is given.
+
+options:
+  -o <file>       output file (only with a single input)
+  -E              stop after macro expansion, write the expanded source
+                  to stdout (or -o)
+  --raw           write the raw big-endian words instead of a DULF object
+  -t, --text      write the object as text (file.o.txt), also picked when
+                  -o ends in .txt
+  -l, --lst       also write a listing (file.lst)
+  -v, --verbose   show the assembler's debug log
+`
+
+const (
+	exitOK = iota
+	exitDiagnostics
+	exitUsage
+)
+
+type options struct {
+	output     string
+	expandOnly bool
+	raw        bool
+	text       bool
+	listing    bool
+	verbose    bool
+	inputs     []string
+}
+
 func main() {
-	scanner := bufio.NewScanner(os.Stdin)
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dubasm: %v\n%s", err, usage)
+		os.Exit(exitUsage)
+	}
+	if !opts.verbose {
+		log.SetOutput(io.Discard)
+	}
 
-	if len(os.Args) == 2 {
-		inputFile, err := os.ReadFile(os.Args[1])
-		if err != nil {
-			log.Println(err)
+	status := exitOK
+	for _, input := range opts.inputs {
+		if !assembleFile(input, opts) {
+			status = exitDiagnostics
 		}
-		r := bytes.NewReader(inputFile)
-		scanner = bufio.NewScanner(r)
+	}
+	os.Exit(status)
+}
+
+func parseArgs(args []string) (opts options, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-o", "--output":
+			if i+1 == len(args) {
+				return opts, fmt.Errorf("%s needs <file>", arg)
+			}
+			i++
+			opts.output = args[i]
+		case "-E":
+			opts.expandOnly = true
+		case "--raw":
+			opts.raw = true
+		case "-t", "--text":
+			opts.text = true
+		case "-l", "--lst":
+			opts.listing = true
+		case "-v", "--verbose":
+			opts.verbose = true
+		case "-h", "--help":
+			fmt.Print(usage)
+			os.Exit(exitOK)
+		default:
+			if strings.HasPrefix(arg, "-") && arg != "-" {
+				return opts, fmt.Errorf("unknown option %s", arg)
+			}
+			opts.inputs = append(opts.inputs, arg)
+		}
+	}
+	if len(opts.inputs) == 0 {
+		opts.inputs = []string{"-"}
+	}
+	if opts.output != "" && len(opts.inputs) > 1 {
+		return opts, fmt.Errorf("-o can't be used with more than one input")
+	}
+	return opts, nil
+}
+
+// Assembles one file, reporting diagnostics on stderr. Returns false on errors.
+func assembleFile(input string, opts options) bool {
+	name := input
+	var code []byte
+	var err error
+	if input == "-" {
+		name = "<stdin>"
+		code, err = io.ReadAll(os.Stdin)
+	} else {
+		code, err = os.ReadFile(input)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dubasm: %v\n", err)
+		return false
 	}
 
-	info := assembler.MakeAssembler()
-	for {
-		if !scanner.Scan() {
-			break
+	if opts.expandOnly {
+		macroProcessor := macroprocessor.MakeMacroProcessor(0)
+		expanded, diags := macroProcessor.ExpandSource(name, string(code))
+		printDiagnostics(diags)
+		if diags.HasErrors() {
+			return false
 		}
-		line := scanner.Text()
-		outLine, err := info.FirstPassString(line)
-		pp.Fprintf(os.Stderr, "processing %v... ", line)
-		if err != nil {
-			if err != dubcc.EmptyLineErr {
-				log.Println(err)
+		// like cc -E, the expansion goes to stdout unless -o is given
+		return writeOutput(opts.output, func(w io.Writer) error {
+			for _, line := range expanded {
+				if _, err := fmt.Fprintln(w, line.Text); err != nil {
+					return err
+				}
 			}
-			continue
+			return nil
+		})
+	}
+
+	info, diags, err := macroprocessor.AssembleSource(name, string(code))
+	printDiagnostics(diags)
+
+	if opts.listing {
+		lstFilename := "a.lst"
+		if input != "-" {
+			lstFilename = withExt(input, ".lst")
+		}
+		if err := assembler.SaveListingFile(info, lstFilename); err != nil {
+			fmt.Fprintf(os.Stderr, "dubasm: %v\n", err)
+			return false
 		}
-		pp.Fprintf(os.Stderr, "%v\n", outLine)
 	}
+	if err != nil {
+		return false
+	}
+
+	if opts.raw {
+		return writeOutput(outputName(input, opts, ".bin"), func(w io.Writer) error {
+			writer := bufio.NewWriter(w)
+			for _, u16 := range info.GetOutput() {
+				high := byte((u16 >> 8) & 0xff)
+				low := byte((u16 >> 0) & 0xff)
+				writer.WriteByte(high)
+				writer.WriteByte(low)
+			}
+			return writer.Flush()
+		})
+	}
+
+	obj, err := info.GenerateObjectFile()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: error: could not generate object file: %v\n", name, err)
+		return false
+	}
+	if opts.text || assembler.IsTextObjectName(opts.output) {
+		return writeOutput(outputName(input, opts, ".o"+assembler.TextObjectExt), obj.WriteText)
+	}
+	return writeOutput(outputName(input, opts, ".o"), obj.Write)
+}
 
-	symbols := info.SecondPass()
-	pp.Fprintf(os.Stderr, "Symbols: %v\n", symbols)
+// -o if given, stdout ("") when reading stdin, otherwise input with ext
+func outputName(input string, opts options, ext string) string {
+	if opts.output != "" || input == "-" {
+		return opts.output
+	}
+	return withExt(input, ext)
+}
 
-	{ // write binary
-		writer := bufio.NewWriter(os.Stdout)
-		for _, u16 := range info.GetOutput() {
-			high := byte((u16 >> 8) & 0xff)
-			low := byte((u16 >> 0) & 0xff)
-			writer.WriteByte(high)
-			writer.WriteByte(low)
+// Writes to filename, or stdout if it is ""
+func writeOutput(filename string, write func(io.Writer) error) bool {
+	var err error
+	if filename == "" {
+		err = write(os.Stdout)
+	} else {
+		var file *os.File
+		if file, err = os.Create(filename); err == nil {
+			err = write(file)
+			// a failed flush only shows up on close
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
 		}
-		writer.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dubasm: %v\n", err)
+		return false
+	}
+	return true
+}
+
+func withExt(path string, ext string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ext
+}
+
+func printDiagnostics(diags dubcc.Diagnostics) {
+	for _, diag := range diags {
+		fmt.Fprintln(os.Stderr, diag)
 	}
 }
@@ -1,32 +1,366 @@
 package main
 
 import (
-	_ "bufio"
 	"bytes"
-	_ "dubcc"
+	"dubcc"
 	"dubcc/assembler"
-	"io"
-	"log"
+	"fmt"
 	"os"
-
-	"github.com/k0kubun/pp/v3"
+	"slices"
+	"strings"
 )
 
+const usage = `usage: dubobjdump <options> <file.o|file.hpx> ...
+
+Shows what is inside DULF objects and executables.
+
+options (short ones can be combined, e.g. -hrt):
+  -h, --section-headers  section headers
+  -t, --syms             symbol table
+  -r, --reloc            relocations
+  -d, --disassemble      disassemble the code sections
+  -s, --full-contents    hex dump of every section
+  -x, --all-headers      same as -h -t -r
+  --help                 show this message
+`
+
+type options struct {
+	headers, syms, relocs, disasm, contents bool
+	files                                   []string
+}
+
 func main() {
-	var r io.Reader = os.Stdin
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dubobjdump: %v\n%s", err, usage)
+		os.Exit(2)
+	}
 
-	if len(os.Args) == 2 {
-		inputFile, err := os.ReadFile(os.Args[1])
+	status := 0
+	for _, file := range opts.files {
+		data, err := os.ReadFile(file)
 		if err != nil {
-			log.Println(err)
+			fmt.Fprintf(os.Stderr, "dubobjdump: %v\n", err)
+			status = 1
+			continue
 		}
-		r = bytes.NewReader(inputFile)
+		obj, err := assembler.Read(bytes.NewReader(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dubobjdump: %s: %v\n", file, err)
+			status = 1
+			continue
+		}
+		dump(file, obj, opts)
 	}
+	os.Exit(status)
+}
 
-	obj, err := assembler.Read(r)
-	if err != nil {
-		panic(err.Error())
+func parseArgs(args []string) (opts options, err error) {
+	long := map[string]*bool{
+		"--section-headers": &opts.headers,
+		"--syms":            &opts.syms,
+		"--reloc":           &opts.relocs,
+		"--disassemble":     &opts.disasm,
+		"--full-contents":   &opts.contents,
+	}
+	short := map[rune]*bool{
+		'h': &opts.headers,
+		't': &opts.syms,
+		'r': &opts.relocs,
+		'd': &opts.disasm,
+		's': &opts.contents,
+	}
+	for _, arg := range args {
+		switch {
+		case arg == "--help":
+			fmt.Print(usage)
+			os.Exit(0)
+		case arg == "--all-headers":
+			opts.headers, opts.syms, opts.relocs = true, true, true
+		case long[arg] != nil:
+			*long[arg] = true
+		case strings.HasPrefix(arg, "--"):
+			return opts, fmt.Errorf("unknown option %s", arg)
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for _, flag := range arg[1:] {
+				switch {
+				case flag == 'x':
+					opts.headers, opts.syms, opts.relocs = true, true, true
+				case short[flag] != nil:
+					*short[flag] = true
+				default:
+					return opts, fmt.Errorf("unknown option -%c", flag)
+				}
+			}
+		default:
+			opts.files = append(opts.files, arg)
+		}
+	}
+	if !(opts.headers || opts.syms || opts.relocs || opts.disasm || opts.contents) {
+		return opts, fmt.Errorf("at least one of -h, -t, -r, -d or -s must be given")
+	}
+	if len(opts.files) == 0 {
+		return opts, fmt.Errorf("no files given")
+	}
+	return opts, nil
+}
+
+func dump(file string, obj *assembler.ObjectFile, opts options) {
+	fmt.Printf("\n%s:     DULF version %d, %v", file, obj.Header.Version, obj.Header.Kind)
+	if obj.HasEntry() {
+		fmt.Printf(", entry 0x%04x", obj.Header.Entry)
+	}
+	if obj.Header.Flags&assembler.DF_RELOCATABLE != 0 {
+		fmt.Printf(", relocatable")
+	}
+	fmt.Printf("\n")
+
+	if opts.headers {
+		dumpHeaders(obj)
+	}
+	if opts.syms {
+		dumpSymbols(obj)
+	}
+	if opts.relocs {
+		dumpRelocations(obj)
+	}
+	if opts.contents {
+		dumpContents(obj)
+	}
+	if opts.disasm {
+		dumpDisassembly(obj)
+	}
+}
+
+func sectionType(t assembler.DulfSection) string {
+	switch t {
+	case assembler.SHT_PROGBITS:
+		return "PROGBITS"
+	case assembler.SHT_SYMTAB:
+		return "SYMTAB"
+	case assembler.SHT_STRTAB:
+		return "STRTAB"
+	case assembler.SHT_RELA:
+		return "RELA"
+	case assembler.SHT_NOBITS:
+		return "NOBITS"
+	default:
+		return fmt.Sprintf("type %d", t)
+	}
+}
+
+func sectionFlags(flags uint32) string {
+	var names []string
+	for _, flag := range []struct {
+		bit  uint32
+		name string
+	}{
+		{assembler.SHF_ALLOC, "ALLOC"},
+		{assembler.SHF_WRITE, "WRITE"},
+		{assembler.SHF_EXECINSTR, "EXEC"},
+	} {
+		if flags&flag.bit != 0 {
+			names = append(names, flag.name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func dumpHeaders(obj *assembler.ObjectFile) {
+	fmt.Printf("\nSections:\n")
+	fmt.Printf("%3s  %-12s  %5s  %6s  %6s  %5s  %-8s  %s\n", "IDX", "NAME", "SIZE", "ADDR", "OFFSET", "ALIGN", "TYPE", "FLAGS")
+	for idx, section := range obj.Sections {
+		header := section.Header
+		fmt.Printf("%3d  %-12s  %5d  0x%04x  %6d  %5d  %-8s  %s\n", idx, section.Name, header.Size/2,
+			header.Address, header.Offset, header.Alignment, sectionType(header.Type), sectionFlags(header.Flags))
+	}
+}
+
+func sectionName(obj *assembler.ObjectFile, idx uint16) string {
+	switch {
+	case idx == assembler.SHN_UNDEF:
+		return "*UND*"
+	case idx == assembler.SHN_ABS:
+		return "*ABS*"
+	case int(idx) < len(obj.Sections):
+		return obj.Sections[idx].Name
+	default:
+		return fmt.Sprintf("section %d", idx)
+	}
+}
+
+// Where the symbol is in memory. Objects keep offsets in the section,
+// executables the final address.
+func symbolAddress(obj *assembler.ObjectFile, symbol assembler.Symbol) dubcc.MachineAddress {
+	if obj.Header.Kind == assembler.ET_REL && int(symbol.Section) < len(obj.Sections) {
+		return obj.Sections[symbol.Section].Header.Address + symbol.Value
+	}
+	return symbol.Value
+}
+
+func dumpSymbols(obj *assembler.ObjectFile) {
+	fmt.Printf("\nSYMBOL TABLE:\n")
+	fmt.Printf("%6s  %-4s  %-6s  %-8s  %5s  %s\n", "VALUE", "BIND", "TYPE", "SECTION", "SIZE", "NAME")
+	for _, symbol := range obj.Symbols {
+		bind := "l"
+		if symbol.GetBinding() == assembler.STB_GLOBAL {
+			bind = "g"
+		}
+		fmt.Printf("0x%04x  %-4s  %-6v  %-8s  %5d  %s\n", symbol.Value, bind, symbol.Type(),
+			sectionName(obj, symbol.Section), symbol.Size, obj.GetString(symbol.NameOffset))
+	}
+}
+
+func relocTypeName(t assembler.RelocationType) string {
+	switch t {
+	case assembler.R_ABSOLUTE:
+		return "R_ABSOLUTE"
+	case assembler.R_RELATIVE:
+		return "R_RELATIVE"
+	default:
+		return fmt.Sprintf("type %d", t)
+	}
+}
+
+// "symbol", "symbol+2" or "symbol-1"
+func relocTarget(obj *assembler.ObjectFile, reloc assembler.Relocation) string {
+	name := fmt.Sprintf("symbol %d", reloc.SymbolIndex())
+	if int(reloc.SymbolIndex()) < len(obj.Symbols) {
+		name = obj.GetString(obj.Symbols[reloc.SymbolIndex()].NameOffset)
+	}
+	if reloc.Addend != 0 {
+		name += fmt.Sprintf("%+d", reloc.Addend)
+	}
+	return name
+}
+
+func dumpRelocations(obj *assembler.ObjectFile) {
+	for idx, section := range obj.Sections {
+		var relocs []assembler.Relocation
+		for _, reloc := range obj.Relocations {
+			if int(reloc.Section) == idx {
+				relocs = append(relocs, reloc)
+			}
+		}
+		if len(relocs) == 0 {
+			continue
+		}
+		slices.SortStableFunc(relocs, func(a, b assembler.Relocation) int {
+			return int(a.Offset) - int(b.Offset)
+		})
+		fmt.Printf("\nRELOCATION RECORDS FOR [%s]:\n", section.Name)
+		fmt.Printf("%6s  %-10s  %s\n", "OFFSET", "TYPE", "VALUE")
+		for _, reloc := range relocs {
+			fmt.Printf("0x%04x  %-10s  %s\n", reloc.Offset, relocTypeName(reloc.RelocType()), relocTarget(obj, reloc))
+		}
+	}
+}
+
+const wordsPerRow = 8
+
+func dumpContents(obj *assembler.ObjectFile) {
+	for _, section := range obj.Sections {
+		fmt.Printf("\nContents of section %s:\n", section.Name)
+		if section.Header.Type == assembler.SHT_NOBITS {
+			fmt.Printf(" (%d words, no data)\n", section.Header.Size/2)
+			continue
+		}
+		for row := 0; row < len(section.Data); row += wordsPerRow {
+			words := section.Data[row:min(row+wordsPerRow, len(section.Data))]
+			var hex, text strings.Builder
+			for _, word := range words {
+				fmt.Fprintf(&hex, " %04x", word)
+				if word >= 0x20 && word < 0x7f {
+					text.WriteRune(rune(word))
+				} else {
+					text.WriteByte('.')
+				}
+			}
+			fmt.Printf(" %04x %-*s  %s\n", section.Header.Address+dubcc.MachineAddress(row), wordsPerRow*5, hex.String(), text.String())
+		}
+	}
+}
+
+func dumpDisassembly(obj *assembler.ObjectFile) {
+	for idx, section := range obj.Sections {
+		if section.Header.Flags&assembler.SHF_EXECINSTR == 0 || section.Header.Type == assembler.SHT_NOBITS {
+			continue
+		}
+		base := section.Header.Address
+
+		labels := make(map[dubcc.MachineAddress][]string)
+		names := make(map[dubcc.MachineAddress]string) // for operands, from every section
+		data := make(map[dubcc.MachineAddress]bool)    // words of STT_DATA symbols
+		for _, symbol := range obj.Symbols {
+			if symbol.Section == assembler.SHN_UNDEF {
+				continue
+			}
+			addr, name := symbolAddress(obj, symbol), obj.GetString(symbol.NameOffset)
+			if _, found := names[addr]; !found && symbol.Section != assembler.SHN_ABS {
+				names[addr] = name
+			}
+			if symbol.Section != uint16(idx) {
+				continue
+			}
+			labels[addr] = append(labels[addr], name)
+			if symbol.Type() == assembler.STT_DATA {
+				for i := range dubcc.MachineAddress(symbol.Size) {
+					data[addr+i] = true
+				}
+			}
+		}
+		refs := make(map[dubcc.MachineAddress]string) // operand -> what it refers to
+		for _, reloc := range obj.Relocations {
+			if int(reloc.Section) == idx {
+				refs[base+reloc.Offset] = relocTarget(obj, reloc)
+			}
+		}
+
+		fmt.Printf("\nDisassembly of section %s:\n", section.Name)
+		for _, line := range disassemble(section.Data, base, names, refs, data) {
+			for _, label := range labels[line.Address] {
+				fmt.Printf("\n%04x <%s>:\n", line.Address, label)
+			}
+			var hex []string
+			for _, word := range line.Words {
+				hex = append(hex, fmt.Sprintf("%04x", word))
+			}
+			fmt.Printf("  %04x:  %-15s  %s\n", line.Address, strings.Join(hex, " "), line)
+		}
+	}
+}
+
+// Disassembles the section with the words of data symbols kept as const,
+// and operands with a relocation named after it
+func disassemble(
+	words []dubcc.MachineWord,
+	base dubcc.MachineAddress,
+	names map[dubcc.MachineAddress]string,
+	refs map[dubcc.MachineAddress]string,
+	data map[dubcc.MachineAddress]bool,
+) (lines []dubcc.DisasmLine) {
+	for start := 0; start < len(words); {
+		addr := base + dubcc.MachineAddress(start)
+		if data[addr] {
+			lines = append(lines, dubcc.DataLine(addr, words[start]))
+			start++
+			continue
+		}
+		end := start
+		for end < len(words) && !data[base+dubcc.MachineAddress(end)] {
+			end++
+		}
+		lines = append(lines, dubcc.Disassemble(words[start:end], addr, names)...)
+		start = end
+	}
+
+	suffix := map[dubcc.AddrMode]string{dubcc.ModeIndirect: ",I", dubcc.ModeRelative: ",R"}
+	for _, line := range lines {
+		for idx, operand := range line.Operands {
+			if ref, found := refs[operand.Address]; found && operand.Mode&(dubcc.ModeMemory|dubcc.ModeRelative) != 0 {
+				line.Operands[idx].Text = ref + suffix[operand.Mode]
+			}
+		}
 	}
-	pp.Println(obj)
-	print(obj.PrettyPrint())
+	return lines
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"dubcc"
+	"fmt"
 	"dubcc/linker"
 	"dubcc/assembler"
 	"log"
@@ -28,6 +29,12 @@ var files	[]SourceFile
 var objects []*ObjectFile
 var linkerMode LinkerMode
 var loadAddress MachineAddress
+var entrySymbol string
+var script *linker.Script
+var archives []*assembler.Archive
+var archiveNames []string
+var textOutput bool
+var imageFormats []dubcc.ImageFormat
 
 func main() {
 	if len(os.Args) >= 2 {
@@ -45,7 +52,34 @@ func main() {
 					if err != nil {
 						log.Fatal("error: failed to parse num")
 					}
+					i++
 				} 
+			case "-T", "--script":
+				if len(os.Args) == i+1 {
+					log.Fatal("error: --script requires a linker script")
+				}
+				i++
+				f, err := os.Open(os.Args[i])
+				if err != nil {
+					log.Fatalf("error: %v", err)
+				}
+				script, err = linker.ParseScript(os.Args[i], f)
+				f.Close()
+				if err != nil {
+					log.Fatal(err)
+				}
+			case "--text":
+				textOutput = true
+			case "--hex":
+				imageFormats = append(imageFormats, dubcc.ImageHex)
+			case "--mem":
+				imageFormats = append(imageFormats, dubcc.ImageWords)
+			case "--entry":
+				if len(os.Args) == i+1 {
+					log.Fatal("error: --entry requires a symbol name")
+				}
+				i++
+				entrySymbol = os.Args[i]
 			default:
 				code, err := os.ReadFile(arg)
 				if err != nil {
@@ -53,9 +87,18 @@ func main() {
 					continue
 				}
 				r := bytes.NewReader(code)
+				if assembler.IsArchive(code) {
+					ar, err := assembler.ReadArchive(r)
+					if err != nil {
+						log.Fatalf("error: %s: %v", arg, err)
+					}
+					archives = append(archives, ar)
+					archiveNames = append(archiveNames, arg)
+					continue
+				}
 				obj, err := assembler.Read(r)
 				if err != nil {
-					panic(err.Error())
+					log.Fatalf("error: %s: %v", arg, err)
 				}
 				file := SourceFile{
 					Name: string(arg),
@@ -70,29 +113,62 @@ func main() {
 	}
 
 	var linkerSingleton *Linker
-	switch linkerMode {
-	case Relocator:
+	switch {
+	case script != nil && linkerMode == Absolute:
+		log.Fatal("error: --script and --absolute can't be used together")
+	case script != nil:
+		linkerSingleton = linker.MakeScriptLinker(script)
+	case linkerMode == Relocator:
 		linkerSingleton = linker.MakeRelocatorLinker()
-	case Absolute:
+	case linkerMode == Absolute:
 		linkerSingleton = linker.MakeAbsoluteLinker(loadAddress)
 	}
 
+	linkerSingleton.EntrySymbol = entrySymbol
+	if len(files) == 0 {
+		log.Fatal("error: no objects to link, only archives")
+	}
+	for i := range archives {
+		linkerSingleton.AddArchive(archiveNames[i], archives[i])
+	}
+
 	for i := range files {
 		objects = append(objects, files[i].Object)
+		linkerSingleton.ObjectNames = append(linkerSingleton.ObjectNames, files[i].Name)
 	}
 
 	executable, err := linkerSingleton.GenerateExecutable(objects)
+	for _, diag := range linkerSingleton.Diags {
+		fmt.Fprintln(os.Stderr, diag)
+	}
 	if err != nil {
-		log.Printf("error: could not generate an executable\n%s\n", err.Error())
+		log.Fatal("error: could not generate an executable")
 	}
 
 	path := files[0].Name
-	base := filepath.Base(path)
+	base := strings.TrimSuffix(filepath.Base(path), assembler.TextObjectExt)
 	if dot := strings.LastIndex(base, "."); dot != -1 {
 		base = base[:dot]
 	}
 	objFilename := base + ".hpx"
+	if textOutput {
+		objFilename += assembler.TextObjectExt
+	}
 	if err := assembler.SaveCompleteObjectFile(executable, objFilename); err != nil {
 		log.Printf("warning: could not save %s: %v", objFilename, err)
 	}
+	mapFilename := base + ".map"
+	if err := linker.SaveMapFile(linkerSingleton, mapFilename); err != nil {
+		log.Printf("warning: could not save %s: %v", mapFilename, err)
+	}
+	// memory image as loaded at address 0, without the relocation info
+	for _, format := range imageFormats {
+		imageFilename := base + ".hex"
+		if format == dubcc.ImageWords {
+			imageFilename = base + ".mem"
+		}
+		if err := dubcc.SaveImageFile(executable.Image(), imageFilename, format); err != nil {
+			log.Printf("warning: could not save %s: %v", imageFilename, err)
+		}
+	}
 }
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"dubcc/assembler"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+const usage = `usage: dubnm [options] <file.o|file.hpx|lib.a> ...
+
+Lists the symbols of DULF objects, executables and archive members:
+address, kind, binding, type and name. Undefined symbols have no address
+and kind U.
+
+kinds: T code, D data, B bss, A absolute, U undefined (lowercase if local)
+
+options:
+  -g, --extern-only     only global symbols
+  -u, --undefined-only  only undefined symbols
+  -n, --numeric-sort    sort by address instead of name
+  -A, --print-file-name start every line with the file name
+  --size                words per section of each file, and the totals
+  --help                show this message
+`
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+)
+
+type options struct {
+	externOnly, undefinedOnly, numericSort, printFileName, size bool
+	files                                                       []string
+}
+
+// An object to list, named "file" or "lib.a(member.o)"
+type input struct {
+	name string
+	obj  *assembler.ObjectFile
+}
+
+func main() {
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dubnm: %v\n%s", err, usage)
+		os.Exit(exitUsage)
+	}
+
+	status := exitOK
+	var inputs []input
+	for _, file := range opts.files {
+		objs, err := readInputs(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dubnm: %v\n", err)
+			status = exitError
+			continue
+		}
+		inputs = append(inputs, objs...)
+	}
+
+	if opts.size {
+		printSizes(inputs)
+	} else {
+		for _, in := range inputs {
+			if len(inputs) > 1 && !opts.printFileName {
+				fmt.Printf("\n%s:\n", in.name)
+			}
+			printSymbols(in, opts)
+		}
+	}
+	os.Exit(status)
+}
+
+func parseArgs(args []string) (opts options, err error) {
+	flags := map[string]*bool{
+		"-g": &opts.externOnly, "--extern-only": &opts.externOnly,
+		"-u": &opts.undefinedOnly, "--undefined-only": &opts.undefinedOnly,
+		"-n": &opts.numericSort, "--numeric-sort": &opts.numericSort,
+		"-A": &opts.printFileName, "--print-file-name": &opts.printFileName,
+		"--size": &opts.size,
+	}
+	for _, arg := range args {
+		switch {
+		case arg == "-h" || arg == "--help":
+			fmt.Print(usage)
+			os.Exit(exitOK)
+		case flags[arg] != nil:
+			*flags[arg] = true
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			return opts, fmt.Errorf("unknown option %s", arg)
+		default:
+			opts.files = append(opts.files, arg)
+		}
+	}
+	if len(opts.files) == 0 {
+		return opts, fmt.Errorf("no files given")
+	}
+	return opts, nil
+}
+
+// The file itself, or every member if it's an archive
+func readInputs(file string) ([]input, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if assembler.IsArchive(data) {
+		ar, err := assembler.ReadArchive(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		var inputs []input
+		for _, member := range ar.Members {
+			inputs = append(inputs, input{fmt.Sprintf("%s(%s)", file, member.Name), member.Object})
+		}
+		return inputs, nil
+	}
+	obj, err := assembler.Read(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return []input{{file, obj}}, nil
+}
+
+// nm's letter for where the symbol lives
+func symbolKind(obj *assembler.ObjectFile, symbol assembler.Symbol) byte {
+	var kind byte
+	switch {
+	case symbol.Section == assembler.SHN_UNDEF:
+		return 'U'
+	case symbol.Section == assembler.SHN_ABS:
+		kind = 'A'
+	case int(symbol.Section) >= len(obj.Sections):
+		kind = '?'
+	case obj.Sections[symbol.Section].Header.Type == assembler.SHT_NOBITS:
+		kind = 'B'
+	case obj.Sections[symbol.Section].Header.Flags&assembler.SHF_EXECINSTR != 0:
+		kind = 'T'
+	default:
+		kind = 'D'
+	}
+	if symbol.GetBinding() == assembler.STB_LOCAL {
+		kind += 'a' - 'A'
+	}
+	return kind
+}
+
+func printSymbols(in input, opts options) {
+	symbols := slices.Clone(in.obj.Symbols)
+	symbols = slices.DeleteFunc(symbols, func(symbol assembler.Symbol) bool {
+		return in.obj.GetString(symbol.NameOffset) == "" ||
+			opts.externOnly && symbol.GetBinding() != assembler.STB_GLOBAL ||
+			opts.undefinedOnly && symbol.Section != assembler.SHN_UNDEF
+	})
+	slices.SortStableFunc(symbols, func(a, b assembler.Symbol) int {
+		if opts.numericSort {
+			// undefined symbols have no address, they go first
+			undefA, undefB := a.Section == assembler.SHN_UNDEF, b.Section == assembler.SHN_UNDEF
+			if c := -cmp.Compare(boolInt(undefA), boolInt(undefB)); c != 0 || undefA {
+				return c
+			}
+			return cmp.Compare(a.Value, b.Value)
+		}
+		return strings.Compare(in.obj.GetString(a.NameOffset), in.obj.GetString(b.NameOffset))
+	})
+
+	for _, symbol := range symbols {
+		if opts.printFileName {
+			fmt.Printf("%s: ", in.name)
+		}
+		addr := fmt.Sprintf("%04x", symbol.Value)
+		if symbol.Section == assembler.SHN_UNDEF {
+			addr = "    "
+		}
+		binding := "STB_LOCAL"
+		if symbol.GetBinding() == assembler.STB_GLOBAL {
+			binding = "STB_GLOBAL"
+		}
+		fmt.Printf("%s %c %-10s %-6v %s\n", addr, symbolKind(in.obj, symbol), binding, symbol.Type(),
+			in.obj.GetString(symbol.NameOffset))
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// One column per section name, in the order they first appear
+func printSizes(inputs []input) {
+	var names []string
+	for _, in := range inputs {
+		for _, section := range in.obj.Sections {
+			if !slices.Contains(names, section.Name) {
+				names = append(names, section.Name)
+			}
+		}
+	}
+
+	for _, name := range names {
+		fmt.Printf("%8s ", name)
+	}
+	fmt.Printf("%8s  %s\n", "total", "file")
+
+	totals := make([]uint32, len(names)+1)
+	row := func(sizes []uint32, name string) {
+		for _, size := range sizes {
+			fmt.Printf("%8d ", size)
+		}
+		fmt.Printf(" %s\n", name)
+	}
+	for _, in := range inputs {
+		sizes := make([]uint32, len(names)+1)
+		for _, section := range in.obj.Sections {
+			words := section.Header.Size / 2
+			sizes[slices.Index(names, section.Name)] += words
+			sizes[len(names)] += words
+		}
+		for idx, size := range sizes {
+			totals[idx] += size
+		}
+		row(sizes, in.name)
+	}
+	if len(inputs) > 1 {
+		row(totals, "(TOTALS)")
+	}
+}
@@ -0,0 +1,12 @@
+; indirect.asm - endereçamento indireto (X,I)
+br start
+ptr: const 3
+val: const 42
+dst: space
+target: const 13
+
+start: load ptr,I
+store dst
+br target,I
+stop
+stop
@@ -0,0 +1,20 @@
+; sections.asm: code, data and bss kept apart
+data
+msg: const 72
+     const 105
+bss
+count: space
+tmp: space
+text
+start main
+main: load msg
+write ACC
+load 0x69
+write ACC
+store count
+load count
+write 10
+stop
+data
+last: const 7
+end
@@ -1,6 +1,7 @@
 add 20
-copy 30 20
+copy x 20 ; was "copy 30 20", rejected now: the destination of copy must be memory or a register
 sub 8
 load 2
 
 stop
+x: space
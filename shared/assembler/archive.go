@@ -0,0 +1,233 @@
+package assembler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+)
+
+// Biblioteca estática: vários objetos DULF num arquivo só, com um índice
+// dos símbolos globais que cada um define. Layout, big-endian:
+//
+//	ArchiveHeader
+//	MemberHeader × MemberCount
+//	IndexEntry   × IndexCount
+//	string table (member and symbol names)
+//	member data, each a complete DULF object
+const ARCHIVE_VERSION uint16 = 1
+
+var ArchiveMagic = [4]byte{'D', 'U', 'L', 'A'}
+
+type ArchiveHeader struct {
+	Magic         [4]byte
+	Version       uint16
+	MemberCount   uint16
+	IndexCount    uint32
+	StringTabSize uint32
+}
+
+type MemberHeader struct {
+	NameOffset uint32
+	Offset     uint32 // from the start of the archive
+	Size       uint32
+}
+
+type IndexEntry struct {
+	NameOffset uint32
+	Member     uint32
+}
+
+type ArchiveMember struct {
+	Name   string
+	Object *ObjectFile
+}
+
+type Archive struct {
+	Members []ArchiveMember
+	Index   map[string]int // global symbol -> member that defines it
+}
+
+var (
+	archiveHeaderSize = uint32(binary.Size(ArchiveHeader{}))
+	memberHeaderSize  = uint32(binary.Size(MemberHeader{}))
+	indexEntrySize    = uint32(binary.Size(IndexEntry{}))
+)
+
+var (
+	BadArchiveErr  = errors.New("not a DULF archive")
+	MemberIndexErr = errors.New("member index out of range")
+)
+
+// Builds the archive and its symbol index. Two members defining the same
+// global symbol are an error, the linker couldn't choose between them.
+func MakeArchive(members []ArchiveMember) (*Archive, error) {
+	ar := &Archive{Members: members, Index: make(map[string]int)}
+	for idx, member := range members {
+		for _, name := range DefinedGlobals(member.Object) {
+			if other, exists := ar.Index[name]; exists {
+				return nil, fmt.Errorf("symbol '%s' is defined by both %s and %s",
+					name, members[other].Name, member.Name)
+			}
+			ar.Index[name] = idx
+		}
+	}
+	return ar, nil
+}
+
+// Names of the global symbols the object defines
+func DefinedGlobals(obj *ObjectFile) (names []string) {
+	for _, symbol := range obj.Symbols {
+		if symbol.Section != SHN_UNDEF && symbol.GetBinding() == STB_GLOBAL {
+			names = append(names, obj.GetString(symbol.NameOffset))
+		}
+	}
+	return names
+}
+
+// Names of the symbols the object uses but doesn't define
+func UndefinedSymbols(obj *ObjectFile) (names []string) {
+	for _, symbol := range obj.Symbols {
+		if name := obj.GetString(symbol.NameOffset); symbol.Section == SHN_UNDEF && name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func IsArchive(data []byte) bool {
+	return len(data) >= 4 && [4]byte(data[:4]) == ArchiveMagic
+}
+
+func (ar *Archive) Write(w io.Writer) error {
+	strtab := &ObjectFile{StringMap: make(map[string]uint32)} // only for AddString
+	strtab.AddString("")
+
+	var data bytes.Buffer
+	members := make([]MemberHeader, len(ar.Members))
+	for idx, member := range ar.Members {
+		start := data.Len()
+		if err := member.Object.Write(&data); err != nil {
+			return fmt.Errorf("%s: %w", member.Name, err)
+		}
+		members[idx] = MemberHeader{
+			NameOffset: strtab.AddString(member.Name),
+			Offset:     uint32(start),
+			Size:       uint32(data.Len() - start),
+		}
+	}
+
+	names := slices.Sorted(maps.Keys(ar.Index))
+	index := make([]IndexEntry, len(names))
+	for idx, name := range names {
+		index[idx] = IndexEntry{NameOffset: strtab.AddString(name), Member: uint32(ar.Index[name])}
+	}
+
+	header := ArchiveHeader{
+		Magic:         ArchiveMagic,
+		Version:       ARCHIVE_VERSION,
+		MemberCount:   uint16(len(members)),
+		IndexCount:    uint32(len(index)),
+		StringTabSize: uint32(len(strtab.StringTable)),
+	}
+	dataOffset := archiveHeaderSize + uint32(len(members))*memberHeaderSize +
+		uint32(len(index))*indexEntrySize + header.StringTabSize
+	for idx := range members {
+		members[idx].Offset += dataOffset
+	}
+
+	for _, v := range []any{header, members, index, strtab.StringTable, data.Bytes()} {
+		if err := binary.Write(w, binary.BigEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads an archive and every member in it, validating them like Read does
+func ReadArchive(r io.Reader) (*Archive, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var header ArchiveHeader
+	if !IsArchive(data) {
+		return nil, &FormatError{Err: BadArchiveErr, What: "archive header",
+			Detail: fmt.Sprintf("magic %q", data[:min(len(data), 4)])}
+	}
+	if err := decode(data, 0, archiveHeaderSize, "archive header", &header); err != nil {
+		return nil, err
+	}
+	if header.Version != ARCHIVE_VERSION {
+		return nil, &FormatError{Err: VersionErr, What: "archive header", Offset: 4,
+			Detail: fmt.Sprintf("version %d, expected %d", header.Version, ARCHIVE_VERSION)}
+	}
+
+	off := uint64(archiveHeaderSize)
+	membersOff := off
+	off += uint64(header.MemberCount) * uint64(memberHeaderSize)
+	indexOff := off
+	off += uint64(header.IndexCount) * uint64(indexEntrySize)
+	table, err := slice(data, off, uint64(header.StringTabSize), "archive string table")
+	if err != nil {
+		return nil, err
+	}
+	strtab := &ObjectFile{StringTable: table}
+
+	ar := &Archive{Index: make(map[string]int)}
+	for idx := range uint64(header.MemberCount) {
+		what := fmt.Sprintf("member %d", idx)
+		off := membersOff + idx*uint64(memberHeaderSize)
+		var member MemberHeader
+		if err := decode(data, off, memberHeaderSize, what, &member); err != nil {
+			return nil, err
+		}
+		name, err := strtab.nameAt(member.NameOffset, what, off)
+		if err != nil {
+			return nil, err
+		}
+		body, err := slice(data, uint64(member.Offset), uint64(member.Size), what)
+		if err != nil {
+			return nil, err
+		}
+		obj, err := Read(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		ar.Members = append(ar.Members, ArchiveMember{Name: name, Object: obj})
+	}
+
+	for idx := range uint64(header.IndexCount) {
+		what := fmt.Sprintf("index entry %d", idx)
+		off := indexOff + idx*uint64(indexEntrySize)
+		var entry IndexEntry
+		if err := decode(data, off, indexEntrySize, what, &entry); err != nil {
+			return nil, err
+		}
+		name, err := strtab.nameAt(entry.NameOffset, what, off)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Member >= uint32(header.MemberCount) {
+			return nil, &FormatError{Err: MemberIndexErr, What: what, Offset: int64(off),
+				Detail: fmt.Sprintf("member %d of %d", entry.Member, header.MemberCount)}
+		}
+		ar.Index[name] = int(entry.Member)
+	}
+	return ar, nil
+}
+
+func SaveArchive(ar *Archive, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return ar.Write(file)
+}
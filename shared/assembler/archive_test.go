@@ -0,0 +1,88 @@
+package assembler
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	members := []ArchiveMember{{Name: "sample.o", Object: assembleSample(t)}}
+	for _, name := range []string{"a.o", "b.o"} {
+		obj := randomObject(rng)
+		for idx := range obj.Symbols {
+			obj.Symbols[idx].SetInfo(STB_LOCAL, STT_NOTYPE) // no clashes with sample.o
+		}
+		members = append(members, ArchiveMember{Name: name, Object: obj})
+	}
+	ar, err := MakeArchive(members)
+	if err != nil {
+		t.Fatalf("MakeArchive: %v", err)
+	}
+	if ar.Index["main"] != 0 {
+		t.Fatalf("main should be indexed to sample.o, index is %v", ar.Index)
+	}
+
+	var buf bytes.Buffer
+	if err := ar.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got, err := ReadArchive(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadArchive: %v", err)
+	}
+	if len(got.Members) != len(members) {
+		t.Fatalf("want %d members, got %d", len(members), len(got.Members))
+	}
+	for idx, member := range members {
+		if got.Members[idx].Name != member.Name {
+			t.Fatalf("member %d: want %s, got %s", idx, member.Name, got.Members[idx].Name)
+		}
+		sameObject(t, member.Object, got.Members[idx].Object)
+	}
+	if len(got.Index) != len(ar.Index) {
+		t.Fatalf("index differs: want %v, got %v", ar.Index, got.Index)
+	}
+	for name, idx := range ar.Index {
+		if got.Index[name] != idx {
+			t.Fatalf("index differs: want %v, got %v", ar.Index, got.Index)
+		}
+	}
+}
+
+func TestArchiveErrors(t *testing.T) {
+	sample := ArchiveMember{Name: "sample.o", Object: assembleSample(t)}
+	if _, err := MakeArchive([]ArchiveMember{sample, sample}); err == nil {
+		t.Fatal("MakeArchive accepted two members defining main")
+	}
+
+	ar, _ := MakeArchive([]ArchiveMember{sample})
+	var buf bytes.Buffer
+	ar.Write(&buf)
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"object", encode(t, sample.Object), BadArchiveErr},
+		{"truncated", valid[:len(valid)-1], TruncatedErr},
+		{"index member", func() []byte {
+			out := bytes.Clone(valid)
+			off := archiveHeaderSize + memberHeaderSize
+			out[off+4] = 0xff // Member high byte of the first index entry
+			return out
+		}(), MemberIndexErr},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadArchive(bytes.NewReader(test.data))
+			if !errors.Is(err, test.want) {
+				t.Fatalf("want %v, got %v", test.want, err)
+			}
+		})
+	}
+}
@@ -4,42 +4,91 @@ import (
 	"dubcc"
 	"errors"
 	"log"
-	"os"
+	"maps"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
 	"fmt"
-	"github.com/k0kubun/pp/v3"
 )
 
 type MachineAddress = dubcc.MachineAddress
 
-var (
-	globalSymbols = make(map[string]bool)
-	externSymbols = make(map[string]bool)
-	maxStackSize  *dubcc.MachineAddress
-	moduleEnded   bool
-)
-
 type Info struct {
 	isa              dubcc.ISA
 	directives       map[string]DirectiveHandler
-	symbols          map[string]dubcc.MachineAddress
-	symbolOccurances map[string][]dubcc.MachineAddress
+	symbols          map[string]dubcc.MachineAddress // offset in the label's section
+	symbolSections   map[string]*asmSection
+	symbolOccurances map[string][]location
+	operandRefs      map[location]operandRef // references that aren't plain absolute
+	kindAt           map[location]SymbolType // STT_FUNC where an instruction starts, STT_DATA for const/space
+	callTargets      map[string]bool
 	undefSyms        UndefSymChain
 	macros           map[string]Macros
 	macroLevel       int
 	macroStack       []MacroFrame
-	output           []dubcc.MachineWord
-	lineCounter      dubcc.MachineAddress
+	sections         []*asmSection // in layout order after SecondPass
+	section          *asmSection   // section being assembled
+	source           dubcc.SourceLine // line being assembled
+	file             string
+	diags            dubcc.Diagnostics
 	StartAddress     dubcc.MachineAddress
+	startSymbol      string           // start <label>, resolved in SecondPass
+	startAt          dubcc.SourceLine // where start was given
+	hasStart         bool
 	stackSize		     dubcc.MachineAddress
 	moduleEnded      bool
+	globalSymbols    map[string]bool // declared with extr
+	symbolDefs       map[string]dubcc.SourceLine // where each label was defined
+	listing          []ListingEntry
+	externSymbols    map[string]bool // declared with extdef
+}
+
+// Seção do módulo, com o seu próprio contador de localização
+type asmSection struct {
+	name    string
+	kind    DulfSection // SHT_PROGBITS or SHT_NOBITS
+	flags   uint32
+	output  []dubcc.MachineWord // always empty for SHT_NOBITS
+	size    dubcc.MachineAddress // location counter, in words
+	address dubcc.MachineAddress // where the section starts when the module is loaded alone
+}
+
+// A word inside a section
+type location struct {
+	section *asmSection
+	offset  dubcc.MachineAddress
+}
+
+func (loc location) address() dubcc.MachineAddress {
+	return loc.section.address + loc.offset
 }
 
+// How a symbol reference is turned into the operand word
+type operandRef struct {
+	addend   int64 // "label+2"
+	relative bool  // "label,R": the word holds label - its own address
+}
+
+// Value of the operand at from referring to a symbol at addr
+func (ref operandRef) value(from location, addr dubcc.MachineAddress) dubcc.MachineWord {
+	value := int64(addr) + ref.addend
+	if ref.relative {
+		value -= int64(from.address())
+	}
+	return dubcc.MachineWord(value)
+}
+
+// Memory image of the module loaded alone: every section at its address,
+// .bss as zeros
 func (info *Info) GetOutput() []dubcc.MachineWord {
-	return info.output
+	var image []dubcc.MachineWord
+	for _, section := range info.sections {
+		image = append(image, make([]dubcc.MachineWord, section.address-dubcc.MachineAddress(len(image)))...)
+		image = append(image, section.output...)
+		image = append(image, make([]dubcc.MachineWord, section.size-dubcc.MachineAddress(len(section.output)))...)
+	}
+	return image
 }
 
 type DirectiveHandler struct {
@@ -50,9 +99,10 @@ type DirectiveHandler struct {
 type UndefSymChainLink struct {
 	addr dubcc.MachineAddress // address for the link data in the binary
 	prev dubcc.MachineAddress // != 0 if this link is not the last for this symbol
-	from dubcc.MachineAddress // address of the unresolved code pos
+	from location             // the unresolved code pos
 	sign byte                 // FIXME: iunno what this one does
 	name string
+	at   dubcc.SourceLine     // where the symbol was used, for diagnostics
 }
 
 type UndefSymChain struct {
@@ -83,8 +133,9 @@ func BoolToInt(val bool) int {
 
 // Lida com os símbolos indefinidos
 func (usymchain *UndefSymChain) ChainSym(
-	from dubcc.MachineAddress,
+	from location,
 	name string,
+	at dubcc.SourceLine,
 ) *UndefSymChainLink {
 	var prevlink *UndefSymChainLink = nil
 	for _, link := range slices.Backward(usymchain.links) {
@@ -105,6 +156,7 @@ func (usymchain *UndefSymChain) ChainSym(
 		from: from,
 		sign: byte('+'),
 		name: name,
+		at:   at,
 	}
 	usymchain.links = append(usymchain.links, newLink)
 	usymchain.top += 8 + 8 + 8 + 1 // u64 + u64 + u64 + byte
@@ -141,11 +193,42 @@ type Repr struct {
 	out    dubcc.MachineWord //Representação binária
 }
 
+// Name reported in diagnostics for lines given through FirstPassString
+func (info *Info) SetFile(name string) {
+	info.file = name
+}
+
+// Problems found so far, in source order
+func (info *Info) Diagnostics() dubcc.Diagnostics {
+	return info.diags
+}
+
 func (info *Info) FirstPassString(rawLine string) (reprs []Repr, err error) {
-	line := strings.TrimSpace(rawLine)
+	return info.FirstPassSource(dubcc.SourceLine{
+		File: info.file,
+		Line: info.source.Line + 1,
+		Text: rawLine,
+	})
+}
+
+// Like FirstPassString but keeps the position the macroprocessor gave the line.
+// Any error besides dubcc.EmptyLineErr is a dubcc.Diagnostic and is also
+// collected in Diagnostics().
+func (info *Info) FirstPassSource(src dubcc.SourceLine) (reprs []Repr, err error) {
+	info.source = src
+	section, start := info.section, info.section.size
+	defer func() {
+		info.listing = append(info.listing, ListingEntry{
+			Source:  src,
+			section: section,
+			Offset:  start,
+			Size:    int(section.size - start),
+		})
+	}()
+	line := strings.TrimSpace(src.Text)
 	parsedLine, err := dubcc.ParseAsmLine(line)
 	if err != nil {
-		return nil, err
+		return nil, info.report(err, "")
 	}
 
 	if parsedLine.Label != "" {
@@ -155,8 +238,13 @@ func (info *Info) FirstPassString(rawLine string) (reprs []Repr, err error) {
 		if parsedLine.Label == "" {
 		  return nil, dubcc.EmptyLineErr
 	  }
+		return nil, nil // label only
+	}
+	reprs, err = info.FirstPass(parsedLine)
+	if err != nil {
+		return nil, info.report(err, parsedLine.Op)
 	}
-	return info.FirstPass(parsedLine)
+	return reprs, nil
 }
 
 func (info *Info) FirstPass(line dubcc.InLine) (reprs []Repr, err error) {
@@ -167,17 +255,42 @@ func (info *Info) FirstPass(line dubcc.InLine) (reprs []Repr, err error) {
 	} else {
 		directive, dfound := info.directives[line.Op]
 		if dfound { //Try the directive
-			directive.f(info, line)
-			return nil, nil
+			if len(line.Args) != directive.numArgs {
+				return nil, info.errorf(line.Op, "%s expects %d arguments, got %d",
+					line.Op, directive.numArgs, len(line.Args))
+			}
+			return nil, directive.f(info, line)
 		}
 	}
-	return nil, errors.New("bad syntax")
+	return nil, info.errorf(line.Op, "unknown instruction or directive %q", line.Op)
+}
+
+// Builds a diagnostic for the line being assembled, pointing at near
+func (info *Info) errorf(near string, format string, args ...any) dubcc.Diagnostic {
+	return dubcc.Diagnostic{
+		File:     info.source.File,
+		Line:     info.source.Line,
+		Col:      dubcc.ColumnOf(info.source.Text, near),
+		Severity: dubcc.SeverityError,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// Records err as a diagnostic, converting it if needed
+func (info *Info) report(err error, near string) error {
+	diag, ok := err.(dubcc.Diagnostic)
+	if !ok {
+		diag = info.errorf(near, "%v", err)
+	}
+	info.diags = append(info.diags, diag)
+	return diag
 }
 
 func (info *Info) handleInstruction(line dubcc.InLine, idata dubcc.Instruction) ([]Repr, error) {
 	r := make([]Repr, 1+idata.NumArgs)
 	if int(idata.NumArgs) != len(line.Args) {
-		return nil, errors.New("number of arguments doesn't match")
+		return nil, info.errorf(line.Op, "%s expects %d arguments, got %d",
+			line.Op, idata.NumArgs, len(line.Args))
 	}
 	r[0] = Repr{
 		tag:   ReprComplete,
@@ -185,12 +298,41 @@ func (info *Info) handleInstruction(line dubcc.InLine, idata dubcc.Instruction)
 		out:   idata.Repr,
 	}
 
+	if info.section.kind == SHT_NOBITS {
+		return nil, info.errorf(line.Op, "%s: instructions can't be placed in %s", line.Op, info.section.name)
+	}
+	info.kindAt[location{info.section, info.section.size}] = STT_FUNC
+
+	// validate every operand against the ISA before touching any table
+	for index, arg := range line.Args {
+		mode, err := info.operandMode(arg)
+		if err != nil {
+			return nil, info.errorf(arg, "%s: %v", line.Op, err)
+		}
+		if !idata.Allows(index, mode) {
+			return nil, info.errorf(arg,
+				"%s: operand %d (%s) can't be %v, allowed modes: %v",
+				line.Op, index+1, arg, mode, idata.Modes[index])
+		}
+	}
+
 	for index, arg := range line.Args {
 		index += 1
 		repr := &r[index]
 
-		//1 - try constant interpretation
 		repr.input = arg
+		//0 - strip the indirect and relative mode markers
+		arg, indirect := CutIndirect(arg)
+		if indirect {
+			indflag := dubcc.MachineWord(dubcc.OpIndirectAFlag * BoolToInt(index == 1))
+			indflag |= dubcc.MachineWord(dubcc.OpIndirectBFlag * BoolToInt(index == 2))
+			r[0].out |= indflag
+		}
+		arg, relative := CutRelative(arg)
+		if relative {
+			r[0].out |= dubcc.OpRelativeFlag
+		}
+		//1 - try constant interpretation
 		num, err := ParseNum(arg)
 		if err == nil {
 			repr.tag = ReprComplete
@@ -215,6 +357,15 @@ func (info *Info) handleInstruction(line dubcc.InLine, idata dubcc.Instruction)
 			}
 		}
 		{ //3 - check symbol table
+			// the address is only final after SecondPass lays out the sections
+			from := location{info.section, info.section.size + dubcc.MachineAddress(index)}
+			arg, addend := CutAddend(arg)
+			if addend != 0 || relative {
+				info.operandRefs[from] = operandRef{addend: addend, relative: relative}
+			}
+			if idata.Name == "call" {
+				info.callTargets[arg] = true
+			}
 			lookup, found := info.symbols[arg]
 			if found {
 				repr.tag = ReprComplete
@@ -222,44 +373,183 @@ func (info *Info) handleInstruction(line dubcc.InLine, idata dubcc.Instruction)
 				repr.out = dubcc.MachineWord(lookup)
 			} else {
 				//4 - new link should be added
-				from := dubcc.MachineAddress(len(info.output) + index)
-				newLink := info.undefSyms.ChainSym(from, arg)
+				newLink := info.undefSyms.ChainSym(from, arg, info.source)
 				repr.tag = ReprPartial
 				repr.symbol = arg
 				repr.out = dubcc.MachineWord(newLink.addr)
 			}
-			info.symbolOccurances[arg] = append(
-				info.symbolOccurances[arg],
-				dubcc.MachineAddress(len(info.output) + index),
-			)
+			info.symbolOccurances[arg] = append(info.symbolOccurances[arg], from)
 		}
-		// TODO/FIXME: decide which
-		// syntax we should use to
-		// signify indirect mode and implement it
 	}
 
 	for _, repr := range r {
-		pp.Fprintf(os.Stderr, "adding %v @ %v\n", repr.out, len(info.output))
-		info.output = append(info.output, repr.out)
+		log.Printf("adding %v @ %v", repr.out, info.section.size)
+		info.emit(repr.out)
 	}
-	
-	info.lineCounter = dubcc.MachineAddress(len(info.output))
 
 	return r, nil
 }
 
+// Lays out the sections and patches every symbol reference with its
+// address. Returns the address of each symbol.
 func (info *Info) SecondPass() (map[string]dubcc.MachineAddress, error) {
+	info.layoutSections()
 	for _, link := range info.undefSyms.links {
-		sym, found := info.symbols[link.name]
-		if !found {
-			if !IsExternalSymbol(link.name) {
-				log.Printf("%s", fmt.Sprint(globalSymbols))
-				return nil, fmt.Errorf("undefined symbol: %v (%v)", link.name, link)
+		if _, found := info.symbols[link.name]; !found && !info.IsExternalSymbol(link.name) {
+			info.diags.Errorf(link.at.File, link.at.Line, dubcc.ColumnOf(link.at.Text, link.name),
+				"undefined symbol %s", link.name)
+		}
+	}
+	addresses := make(map[string]dubcc.MachineAddress)
+	for name := range info.symbols {
+		addresses[name], _ = info.SymbolAddress(name)
+	}
+	for name, froms := range info.symbolOccurances {
+		if addr, found := addresses[name]; found {
+			for _, from := range froms {
+				from.section.output[from.offset] = info.operandRefs[from].value(from, addr)
 			}
 		}
-		info.output[link.from] = dubcc.MachineWord(sym)
 	}
-	return info.symbols, nil
+	if info.startSymbol != "" {
+		at := info.startAt
+		if sym, found := addresses[info.startSymbol]; found {
+			info.StartAddress = sym
+		} else if info.IsExternalSymbol(info.startSymbol) {
+			info.diags.Errorf(at.File, at.Line, dubcc.ColumnOf(at.Text, info.startSymbol),
+				"entry point %s must be defined in this module", info.startSymbol)
+		} else {
+			info.diags.Errorf(at.File, at.Line, dubcc.ColumnOf(at.Text, info.startSymbol),
+				"undefined entry point %s", info.startSymbol)
+		}
+	}
+	if err := info.diags.Err(); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// Orders the sections (code, then data, then bss) and places them one
+// after the other starting at 0, the layout used when the module is
+// loaded without linking.
+func (info *Info) layoutSections() {
+	rank := func(section *asmSection) int {
+		switch {
+		case section.kind == SHT_NOBITS:
+			return 2
+		case section.flags&SHF_EXECINSTR != 0:
+			return 0
+		default:
+			return 1
+		}
+	}
+	slices.SortStableFunc(info.sections, func(a, b *asmSection) int {
+		return rank(a) - rank(b)
+	})
+	address := dubcc.MachineAddress(0)
+	for _, section := range info.sections {
+		section.address = address
+		address += section.size
+	}
+}
+
+// Address of a label in the layout made by SecondPass
+func (info *Info) SymbolAddress(name string) (dubcc.MachineAddress, bool) {
+	offset, found := info.symbols[name]
+	if !found {
+		return 0, false
+	}
+	return info.symbolSections[name].address + offset, true
+}
+
+// Switches to the named section, creating it on first use. Names
+// starting with .bss hold no data, .text is code, anything else is data.
+func (info *Info) switchSection(name string) {
+	idx := slices.IndexFunc(info.sections, func(section *asmSection) bool {
+		return section.name == name
+	})
+	if idx >= 0 {
+		info.section = info.sections[idx]
+		return
+	}
+	section := &asmSection{name: name, kind: SHT_PROGBITS, flags: SHF_ALLOC | SHF_WRITE}
+	switch {
+	case strings.HasPrefix(name, ".bss"):
+		section.kind = SHT_NOBITS
+	case strings.HasPrefix(name, ".text"):
+		section.flags = SHF_ALLOC | SHF_EXECINSTR
+	}
+	info.sections = append(info.sections, section)
+	info.section = section
+}
+
+// Appends a word to the current section
+func (info *Info) emit(word dubcc.MachineWord) {
+	info.section.output = append(info.section.output, word)
+	info.section.size += 1
+}
+
+// Decide the addressing mode an operand was written in
+func (info *Info) operandMode(arg string) (dubcc.AddrMode, error) {
+	base, indirect := CutIndirect(arg)
+	base, relative := CutRelative(base)
+	_, numErr := ParseNum(base)
+	_, isReg := info.isa.Registers[base]
+	switch {
+	case indirect && relative:
+		return 0, fmt.Errorf("operand %v can't be both indirect and relative", arg)
+	case indirect && (numErr == nil || isReg):
+		return 0, fmt.Errorf("indirect operand %v must be a memory address", arg)
+	case relative && (numErr == nil || isReg):
+		return 0, fmt.Errorf("relative operand %v must be a label", arg)
+	case indirect:
+		return dubcc.ModeIndirect, nil
+	case relative:
+		return dubcc.ModeRelative, nil
+	case numErr == nil:
+		return dubcc.ModeImmediate, nil
+	case isReg:
+		return dubcc.ModeRegister, nil
+	default:
+		return dubcc.ModeDirect, nil
+	}
+}
+
+// Operandos indiretos usam a sintaxe do Calingaert: "load X,I"
+func CutIndirect(arg string) (string, bool) {
+	for _, suffix := range []string{",I", ",i"} {
+		if base, found := strings.CutSuffix(arg, suffix); found {
+			return base, true
+		}
+	}
+	return arg, false
+}
+
+// Operandos relativos ao PC: "br loop,R" guarda loop menos o endereço
+// da própria palavra do operando
+func CutRelative(arg string) (string, bool) {
+	for _, suffix := range []string{",R", ",r"} {
+		if base, found := strings.CutSuffix(arg, suffix); found {
+			return base, true
+		}
+	}
+	return arg, false
+}
+
+// Splits "label+2" or "label-1" into the label and its addend
+func CutAddend(arg string) (string, int64) {
+	at := strings.LastIndexAny(arg, "+-")
+	if at <= 0 {
+		return arg, 0
+	}
+	num, err := ParseNum(arg[at+1:])
+	if err != nil {
+		return arg, 0
+	}
+	if arg[at] == '-' {
+		return arg[:at], -int64(num)
+	}
+	return arg[:at], int64(num)
 }
 
 func ParseNum(in string) (num MachineAddress, err error) {
@@ -290,11 +580,13 @@ func ParseNum(in string) (num MachineAddress, err error) {
 
 func (info *Info) registerLabelAt(name string, where dubcc.MachineAddress) {
 	info.symbols[name] = where
+	info.symbolSections[name] = info.section
+	info.symbolDefs[name] = info.source
 }
 
 func (info *Info) registerLabel(name string) {
-	info.registerLabelAt(name, info.lineCounter)
-	log.Printf("Registered label \"%s\" @ %d\n", name, info.lineCounter)
+	info.registerLabelAt(name, info.section.size)
+	log.Printf("Registered label \"%s\" @ %s+%d\n", name, info.section.name, info.section.size)
 }
 
 func (info *Info) GetSymbols() []string {
@@ -305,39 +597,69 @@ func (info *Info) GetSymbols() []string {
 	return syms
 }
 
-func (info *Info) SymbolOccurances() map[string][]dubcc.MachineAddress {
-	return info.symbolOccurances
-}
-
-func (info *Info) registerConst(name string, val dubcc.MachineWord) {
+func (info *Info) registerConst(name string, val dubcc.MachineWord) error {
+	if info.section.kind == SHT_NOBITS {
+		return fmt.Errorf("%s has no data, only space can be used in it", info.section.name)
+	}
 	if name != "" {
-		info.symbols[name] = info.lineCounter
+		info.registerLabelAt(name, info.section.size)
 	}
-	info.output = append(info.output, val)
-	info.lineCounter += 1
+	info.kindAt[location{info.section, info.section.size}] = STT_DATA
+	info.emit(val)
+	return nil
 }
 
-type ObjectInfo struct {
-	globalSymbols  map[string]bool
-	sections       map[string]*Section
-	currentSection *Section
+// STT_FUNC for code labels and call targets, STT_DATA for const and space
+// labels and anything else outside of code
+func (info *Info) SymbolType(name string) SymbolType {
+	if section, found := info.symbolSections[name]; found {
+		if kind, found := info.kindAt[location{section, info.symbols[name]}]; found {
+			return kind
+		}
+		if section.flags&SHF_EXECINSTR == 0 {
+			return STT_DATA
+		}
+	}
+	if info.callTargets[name] {
+		return STT_FUNC
+	}
+	return STT_NOTYPE
 }
 
-var globalObjectInfoMap map[*Info]*ObjectInfo
+// Words from the symbol to the next symbol of its section, or to the end
+// of the section
+func (info *Info) SymbolSize(name string) uint32 {
+	section, found := info.symbolSections[name]
+	if !found {
+		return 0
+	}
+	start, end := info.symbols[name], section.size
+	for other, offset := range info.symbols {
+		if info.symbolSections[other] == section && offset > start && offset < end {
+			end = offset
+		}
+	}
+	return uint32(end - start)
+}
 
+// Every Info carries all of its module's state, so modules can be
+// assembled independently (and concurrently) in the same process.
 func MakeAssembler() Info {
-  // when assembling more than 1 file
-	if globalObjectInfoMap == nil {
-		globalObjectInfoMap = make(map[*Info]*ObjectInfo)
-	}
-	
 	info := Info{
 		isa:        dubcc.GetDefaultISA(),
 		directives: Directives(),
 		symbols:    make(map[string]dubcc.MachineAddress),
-		symbolOccurances: make(map[string][]dubcc.MachineAddress),
+		symbolSections: make(map[string]*asmSection),
+		symbolOccurances: make(map[string][]location),
+		operandRefs: make(map[location]operandRef),
+		kindAt:     make(map[location]SymbolType),
+		callTargets: make(map[string]bool),
 		macros:     make(map[string]Macros),
+		globalSymbols: make(map[string]bool),
+		externSymbols: make(map[string]bool),
+		symbolDefs: make(map[string]dubcc.SourceLine),
 	}
+	info.switchSection(".text")
 	
 	return info
 }
@@ -346,8 +668,13 @@ func Directives() map[string]DirectiveHandler {
 	return map[string]DirectiveHandler{
 		"space": {
 			f: func(info *Info, line dubcc.InLine) error {
-				info.registerConst(line.Label, 0)
-				return nil
+				if info.section.kind == SHT_NOBITS {
+					// reserved, but not stored in the object
+					info.kindAt[location{info.section, info.section.size}] = STT_DATA
+					info.section.size += 1
+					return nil
+				}
+				return info.registerConst(line.Label, 0)
 			},
 			numArgs: 0,
 		},
@@ -358,16 +685,28 @@ func Directives() map[string]DirectiveHandler {
 					err = fmt.Errorf("can't decide value for const %v: %v", line.Label, err)
 					return err
 				}
-				info.registerConst(line.Label, dubcc.MachineWord(num))
+				return info.registerConst(line.Label, dubcc.MachineWord(num))
+			},
+			numArgs: 1,
+		},
+		"section": {
+			f: func(info *Info, line dubcc.InLine) error {
+				name := line.Args[0]
+				if !strings.HasPrefix(name, ".") {
+					return fmt.Errorf("section name %s must start with a dot", name)
+				}
+				info.switchSection(name)
 				return nil
 			},
 			numArgs: 1,
 		},
+		"text": sectionDirective(".text"),
+		"data": sectionDirective(".data"),
+		"bss":  sectionDirective(".bss"),
 		"end": {
 			f: func(info *Info, line dubcc.InLine) error {
 				info.moduleEnded = true
-				moduleEnded = true
-				log.Printf("module ended at address 0x%x", info.lineCounter)
+				log.Printf("module ended at %s+0x%x", info.section.name, info.section.size)
 				return nil
 			},
 			numArgs: 0,
@@ -375,7 +714,7 @@ func Directives() map[string]DirectiveHandler {
 		"extr": {
 			f: func(info *Info, line dubcc.InLine) error {
 				symbolName := line.Args[0]
-				globalSymbols[symbolName] = true
+				info.globalSymbols[symbolName] = true
 				log.Printf("declared global symbol: %s", symbolName)
 				if addr, exists := info.symbols[symbolName]; exists {
 					log.Printf("symbol %s already defined at 0x%x, marking as global", symbolName, addr)
@@ -389,7 +728,10 @@ func Directives() map[string]DirectiveHandler {
 				if line.Label == "" {
 					return fmt.Errorf("extdef requires a label, got %s", line.Label)
 				}
-				externSymbols[line.Label] = true
+				info.externSymbols[line.Label] = true
+				// the label was registered as a local address, but it names an import
+				delete(info.symbols, line.Label)
+				delete(info.symbolSections, line.Label)
 				log.Printf("declared external symbol: %s", line.Label)
 				return nil
 			},
@@ -401,9 +743,8 @@ func Directives() map[string]DirectiveHandler {
 				if err != nil {
 					return fmt.Errorf("can't parse stack size %v: %v", line.Args[0], err)
 				}
-				stackSize := dubcc.MachineAddress(num)
-				maxStackSize = &stackSize
-				log.Printf("set maximum stack size to %d words", stackSize)
+				info.stackSize = dubcc.MachineAddress(num)
+				log.Printf("set maximum stack size to %d words", info.stackSize)
 				return nil
 			},
 			numArgs: 1,
@@ -416,9 +757,13 @@ func Directives() map[string]DirectiveHandler {
 				addrStr := line.Args[0]
 				addr, err := ParseNum(addrStr)
 				if err != nil {
-					return fmt.Errorf("invalid start address: %v", err)
+					// start <label>, the label may not be defined yet
+					info.startSymbol = addrStr
+				} else {
+					info.StartAddress = dubcc.MachineAddress(addr)
 				}
-				info.StartAddress = dubcc.MachineAddress(addr)
+				info.startAt = info.source
+				info.hasStart = true
 				return nil
 			},
 			numArgs: 1,
@@ -434,10 +779,39 @@ func Directives() map[string]DirectiveHandler {
 	}
 }
 
-func IsGlobalSymbol(name string) bool {
-	return globalSymbols[name]
+// text, data and bss are short for section .text etc.
+func sectionDirective(name string) DirectiveHandler {
+	return DirectiveHandler{
+		f: func(info *Info, line dubcc.InLine) error {
+			info.switchSection(name)
+			return nil
+		},
+		numArgs: 0,
+	}
+}
+
+func (info *Info) IsGlobalSymbol(name string) bool {
+	return info.globalSymbols[name]
+}
+
+func (info *Info) IsExternalSymbol(name string) bool {
+	return info.externSymbols[name]
+}
+
+// Symbols declared with extdef, sorted by name
+func (info *Info) ExternalSymbols() []string {
+	return slices.Sorted(maps.Keys(info.externSymbols))
+}
+
+// Entry point given with the start directive, if any
+func (info *Info) Entry() (dubcc.MachineAddress, bool) {
+	return info.StartAddress, info.hasStart
+}
+
+func (info *Info) StackSize() dubcc.MachineAddress {
+	return info.stackSize
 }
 
-func IsExternalSymbol(name string) bool {
-	return externSymbols[name]
+func (info *Info) ModuleEnded() bool {
+	return info.moduleEnded
 }
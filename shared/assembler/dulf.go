@@ -1,19 +1,37 @@
 package assembler
 
 import (
+	"bytes"
 	"dubcc"
 	"encoding/binary"
+	"errors"
 	"io"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"github.com/k0kubun/pp/v3"
 )
 
+type ObjectKind     uint16
 type DulfSection    uint32
 type SymbolBinding  uint8
 type SymbolType     uint8
 type RelocationType uint32
 
+// Version 1 was the unversioned layout, whose section count sits where
+// Version is now. Version 2 had no section in relocations.
+const DULF_VERSION uint16 = 3
+
+var DulfMagic = [4]byte{'D', 'U', 'L', 'F'}
+
+const (
+	ET_NONE ObjectKind = iota // unknown
+	ET_REL                    // relocatable object, from the assembler
+	ET_EXEC                   // executable, from the linker
+	ET_LIB                    // library of objects
+)
+
 const (
 	SHT_PROGBITS  DulfSection = iota  // code
 	SHT_SYMTAB                        // symbol table (not using yet)
@@ -22,6 +40,12 @@ const (
 	SHT_NOBITS                        // uninitialized data (BSS) (not using yet)
 )
 
+const (
+	SHF_WRITE     uint32 = 0x1 // writable
+	SHF_ALLOC     uint32 = 0x2 // occupies memory when loaded
+	SHF_EXECINSTR uint32 = 0x4 // code
+)
+
 const (
 	STB_LOCAL  	SymbolBinding = iota // local symbols
 	STB_GLOBAL                       // global symbols
@@ -34,6 +58,16 @@ const (
 	STT_SECTION                   // section
 )
 
+const (
+	DF_ENTRY       uint16 = 0x1 // header Entry is valid
+	DF_RELOCATABLE uint16 = 0x2 // relocations are rebased to the executable, it can load anywhere
+)
+
+const (
+	SHN_UNDEF uint16 = 0xFFF1 // section of undefined (extdef) symbols
+	SHN_ABS   uint16 = 0xFFF2 // section of absolute symbols, e.g. from a linker script
+)
+
 const (
 	R_ABSOLUTE RelocationType = 1 // direct reference
 	R_RELATIVE RelocationType = 2 // PC relative reference
@@ -41,6 +75,10 @@ const (
 
 type DulfHeader struct {
 	Magic         [4]byte	// magic number "DULF"
+	Version       uint16  // DULF_VERSION
+	HeaderSize    uint16  // size of this header in bytes
+	Kind          ObjectKind // ET_* kind of file
+	Flags         uint16     // DF_* flags
 	SectionCount  uint16 	// number of sections
 	SymbolCount   uint16 	// number of symbols
 	RelocCount    uint16 	// number of relocations
@@ -49,6 +87,7 @@ type DulfHeader struct {
 	RelocOffset   uint32 	// offset to relocation table
 	StringOffset  uint32 	// offset to string table
 	StringTabSize uint32  // string table size in bytes
+	Entry         dubcc.MachineAddress  // entry point, if DF_ENTRY is set
 }
 
 type SectionHeader struct {
@@ -66,16 +105,17 @@ type SectionHeader struct {
 type Symbol struct {
 	NameOffset uint32      					// offset in string table
 	Value      dubcc.MachineAddress	// symbol value
-	Size       uint32       				// symbol size
+	Size       uint32       				// symbol size, in words
 	Info       SymbolBinding        // symbol type and binding
 	Other      uint8        				// reserved
 	Section    uint16       				// section index
 }
 
 type Relocation struct {
-	Offset     dubcc.MachineAddress // location to relocate
+	Offset     dubcc.MachineAddress // location to relocate, in words from the section start
 	Info       uint32               // relocation type and symbol index
 	Addend     int64                // for relocation
+	Section    uint16               // section holding Offset
 }
 
 type SourceFile struct {
@@ -100,6 +140,34 @@ type Section struct {
 }
 
 
+func (kind ObjectKind) String() string {
+	switch kind {
+	case ET_REL:
+		return "relocatable object"
+	case ET_EXEC:
+		return "executable"
+	case ET_LIB:
+		return "library"
+	default:
+		return fmt.Sprintf("unknown kind %d", uint16(kind))
+	}
+}
+
+func (t SymbolType) String() string {
+	switch t {
+	case STT_NOTYPE:
+		return "notype"
+	case STT_DATA:
+		return "data"
+	case STT_FUNC:
+		return "func"
+	case STT_SECTION:
+		return "section"
+	default:
+		return fmt.Sprintf("type %d", uint8(t))
+	}
+}
+
 func (s *Symbol) Type() SymbolType {
 	return SymbolType(s.Info & 0xf)
 }
@@ -144,26 +212,34 @@ func (info *Info) GenerateObjectFile() (*ObjectFile, error) {
 	
 	obj.AddString("")
 	
-	textSection := Section{
-		Name: ".text",
-		Header: SectionHeader{
-			Type:  SHT_PROGBITS,
-			Flags: 0x6, // allocatable + executable
-			Size:  uint32(len(info.GetOutput()) * 2), // 2 bytes per word
-		},
-		Data: info.GetOutput(),
-	}
-	textSection.Header.NameOffset = obj.AddString(".text")
-	obj.Sections = []Section{textSection}
+	// already in layout order, SecondPass sorted them
+	for _, asmSection := range info.sections {
+		section := Section{
+			Name: asmSection.name,
+			Header: SectionHeader{
+				Type:    asmSection.kind,
+				Flags:   asmSection.flags,
+				Address: asmSection.address,
+				Size:    uint32(asmSection.size * 2), // 2 bytes per word
+			},
+			Data: asmSection.output,
+		}
+		section.Header.NameOffset = obj.AddString(section.Name)
+		obj.Sections = append(obj.Sections, section)
+	}
 	
 	obj.buildSymbolTable(info)
 	obj.buildRelocationTable(info)
 	
-	obj.Header.Magic = [4]byte{'D', 'U', 'L', 'F'}
+	obj.Header.Magic = DulfMagic
+	obj.Header.Kind = ET_REL
 	obj.Header.SectionCount = uint16(len(obj.Sections))
 	obj.Header.SymbolCount = uint16(len(obj.Symbols))
 	obj.Header.RelocCount = uint16(len(obj.Relocations))
 	obj.Header.StringTabSize = uint32(len(obj.StringTable))
+	if entry, found := info.Entry(); found {
+		obj.SetEntry(entry)
+	}
 	
 	return obj, nil
 }
@@ -180,50 +256,56 @@ func (obj *ObjectFile) AddString(s string) uint32 {
 }
 
 func (obj *ObjectFile) buildSymbolTable(info *Info) {
-	// defined symbols
-	for name, addr := range info.symbols {
+	// defined symbols, sorted so every run produces the same file
+	for _, name := range slices.Sorted(maps.Keys(info.symbols)) {
 		symbol := Symbol{
 			NameOffset: obj.AddString(name),
-			Value:      addr,
-			Size:       8, // default size
-			Section:    0, // all symbols in .text section
+			Value:      info.symbols[name], // offset in its section
+			Size:       info.SymbolSize(name),
+			Section:    info.sectionIndex(info.symbolSections[name]),
 		}
 		
 		// check if symbol is global
-		if IsGlobalSymbol(name) {
-			symbol.SetInfo(STB_GLOBAL, STT_NOTYPE)
+		if info.IsGlobalSymbol(name) {
+			symbol.SetInfo(STB_GLOBAL, info.SymbolType(name))
 		} else {
-			symbol.SetInfo(STB_LOCAL, STT_NOTYPE)
+			symbol.SetInfo(STB_LOCAL, info.SymbolType(name))
 		}
 		
 		obj.Symbols = append(obj.Symbols, symbol)
 	}
 	
 	// external symbols as undefined
-	for externSym := range externSymbols {
-		fmt.Printf("%s in an external symbol\n", externSym)
+	for _, externSym := range info.ExternalSymbols() {
 		symbol := Symbol{
 			NameOffset: obj.AddString(externSym),
 			Value:      0,     // Undefined
 			Size:       0,
-			Section:    0xFFF1, // SHN_UNDEF
+			Section:    SHN_UNDEF,
 		}
-		symbol.SetInfo(STB_GLOBAL, STT_NOTYPE)
+		symbol.SetInfo(STB_GLOBAL, info.SymbolType(externSym))
 		obj.Symbols = append(obj.Symbols, symbol)
 	}
 }
 
 func (obj *ObjectFile) buildRelocationTable(info *Info) {
-	for symb, addrs := range info.SymbolOccurances() {
-		for _, addr := range addrs {
+	occurances := info.symbolOccurances
+	for _, symb := range slices.Sorted(maps.Keys(occurances)) {
+		for _, from := range occurances[symb] {
+			ref := info.operandRefs[from]
 			reloc := Relocation{
-				Offset:     addr,
-				Addend:     0,
+				Offset:     from.offset,
+				Addend:     ref.addend,
+				Section:    info.sectionIndex(from.section),
+			}
+			relocType := R_ABSOLUTE
+			if ref.relative {
+				relocType = R_RELATIVE
 			}
 
 			for i, sym := range obj.Symbols {
 				if obj.GetString(sym.NameOffset) == symb {
-					reloc.SetInfo(uint32(i), R_ABSOLUTE)
+					reloc.SetInfo(uint32(i), relocType)
 					break
 				}
 			}
@@ -232,17 +314,71 @@ func (obj *ObjectFile) buildRelocationTable(info *Info) {
 	}
 }
 
+func (info *Info) sectionIndex(section *asmSection) uint16 {
+	return uint16(slices.Index(info.sections, section))
+}
+
+// Like StringAt, but "" for a bad offset
 func (obj *ObjectFile) GetString(offset uint32) string {
+	str, _ := obj.StringAt(offset)
+	return str
+}
+
+// The NUL terminated string at offset in the string table
+func (obj *ObjectFile) StringAt(offset uint32) (string, error) {
 	if offset >= uint32(len(obj.StringTable)) {
-		return ""
+		return "", fmt.Errorf("string offset %d outside of %d byte string table", offset, len(obj.StringTable))
+	}
+	end := bytes.IndexByte(obj.StringTable[offset:], 0)
+	if end < 0 {
+		return "", fmt.Errorf("string at offset %d is not terminated", offset)
+	}
+	return string(obj.StringTable[offset : offset+uint32(end)]), nil
+}
+
+func (obj *ObjectFile) SetEntry(entry dubcc.MachineAddress) {
+	obj.Header.Entry = entry
+	obj.Header.Flags |= DF_ENTRY
+}
+
+func (obj *ObjectFile) HasEntry() bool {
+	return obj.Header.Flags&DF_ENTRY != 0
+}
+
+// Memory image of the object, each section at the address in its header.
+// Execution starts at the header's entry point, or at the lowest section
+// address if the object has none.
+func (obj *ObjectFile) Image() dubcc.Image {
+	img := dubcc.Image{}
+	for idx, section := range obj.Sections {
+		data := section.Data
+		if section.Header.Type == SHT_NOBITS {
+			data = make([]dubcc.MachineWord, section.Header.Size/2)
+		}
+		img.Segments = append(img.Segments, dubcc.Segment{
+			Name:    section.Name,
+			Address: section.Header.Address,
+			Data:    data,
+		})
+		if idx == 0 || section.Header.Address < img.Entry {
+			img.Entry = section.Header.Address
+		}
+	}
+	if obj.HasEntry() {
+		img.Entry = obj.Header.Entry
 	}
-	end := offset
-	for end < uint32(len(obj.StringTable)) && obj.StringTable[end] != 0 {
-		end++
+	if obj.Header.Flags&DF_RELOCATABLE != 0 {
+		img.Relocatable = true
+		for _, reloc := range obj.Relocations {
+			if reloc.RelocType() == R_ABSOLUTE {
+				img.Fixups = append(img.Fixups, dubcc.Fixup{Segment: int(reloc.Section), Offset: reloc.Offset})
+			}
+		}
 	}
-	return string(obj.StringTable[offset:end])
+	return img
 }
 
+// Writes binary DULF, or the text form when filename ends in TextObjectExt
 func SaveCompleteObjectFile(obj *ObjectFile, filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
@@ -250,6 +386,9 @@ func SaveCompleteObjectFile(obj *ObjectFile, filename string) error {
 	}
 	defer file.Close()
 
+	if IsTextObjectName(filename) {
+		return obj.WriteText(file)
+	}
 	return obj.Write(file)
 }
 
@@ -266,14 +405,33 @@ func machineWordsToBytes(words []dubcc.MachineWord) []byte {
 	return buf
 }
 
-func (obj *ObjectFile) Write(w io.Writer) error {
-	headerSize := uint32(30) // fixed header size??
-	sectionHeaderSize := uint32(len(obj.Sections) * 34) // 34 bytes per section header
-	
+// Tamanho de cada estrutura no arquivo, em bytes
+var (
+	headerSize        = uint32(binary.Size(DulfHeader{}))
+	sectionHeaderSize = uint32(binary.Size(SectionHeader{}))
+	symbolSize        = uint32(binary.Size(Symbol{}))
+	relocationSize    = uint32(binary.Size(Relocation{}))
+)
+
+// Fills in the header fields and section offsets Write computes
+func (obj *ObjectFile) layout() {
+	obj.Header.Magic = DulfMagic
+	obj.Header.Version = DULF_VERSION
+	obj.Header.HeaderSize = uint16(headerSize)
 	obj.Header.SectionOffset = headerSize
-	obj.Header.SymbolOffset = obj.Header.SectionOffset + sectionHeaderSize
-	obj.Header.RelocOffset = obj.Header.SymbolOffset + uint32(len(obj.Symbols)*14) // 20 bytes per symbol
-	obj.Header.StringOffset = obj.Header.RelocOffset + uint32(len(obj.Relocations)*14) // 24 bytes per relocation
+	obj.Header.SymbolOffset = obj.Header.SectionOffset + uint32(len(obj.Sections))*sectionHeaderSize
+	obj.Header.RelocOffset = obj.Header.SymbolOffset + uint32(len(obj.Symbols))*symbolSize
+	obj.Header.StringOffset = obj.Header.RelocOffset + uint32(len(obj.Relocations))*relocationSize
+	obj.Header.StringTabSize = uint32(len(obj.StringTable))
+	dataOffset := obj.Header.StringOffset + obj.Header.StringTabSize
+	for idx := range obj.Sections {
+		obj.Sections[idx].Header.Offset = dataOffset
+		dataOffset += uint32(len(obj.Sections[idx].Data) * 2) // nothing for SHT_NOBITS
+	}
+}
+
+func (obj *ObjectFile) Write(w io.Writer) error {
+	obj.layout()
 	
 	// header
 	if err := binary.Write(w, binary.BigEndian, obj.Header); err != nil {
@@ -293,13 +451,7 @@ func (obj *ObjectFile) Write(w io.Writer) error {
 	}
 	// relocations
 	for _, reloc := range obj.Relocations {
-		if err := binary.Write(w, binary.BigEndian, reloc.Offset); err != nil {
-			return err
-		}
-		if err := binary.Write(w, binary.BigEndian, reloc.Info); err != nil {
-			return err
-		}
-		if err := binary.Write(w, binary.BigEndian, reloc.Addend); err != nil {
+		if err := binary.Write(w, binary.BigEndian, reloc); err != nil {
 			return err
 		}
 	}
@@ -319,61 +471,186 @@ func (obj *ObjectFile) Write(w io.Writer) error {
 	return nil
 }
 
+var (
+	TruncatedErr    = errors.New("truncated file")
+	BadMagicErr     = errors.New("not a DULF file")
+	VersionErr      = errors.New("unsupported DULF version")
+	OffsetRangeErr  = errors.New("offset out of range")
+	SymbolIndexErr  = errors.New("symbol index out of range")
+	SectionIndexErr = errors.New("section index out of range")
+	SectionSizeErr  = errors.New("section size is not a multiple of 2")
+)
+
+// Problem found while reading a DULF file. Err is one of the *Err values
+// above, so callers can use errors.Is.
+type FormatError struct {
+	Err    error
+	What   string // part of the file, e.g. "symbol 3"
+	Offset int64  // file offset of that part
+	Detail string
+}
+
+func (e *FormatError) Error() string {
+	msg := fmt.Sprintf("%s at offset %d: %v", e.What, e.Offset, e.Err)
+	if e.Detail != "" {
+		msg += " (" + e.Detail + ")"
+	}
+	return msg
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// Reads and validates a whole DULF file. Every table is located through
+// the offsets in the header and checked against the file size, so a
+// corrupt file gives a *FormatError instead of a half read object.
+// Text objects (see WriteText) are recognized and read too.
 func Read(r io.Reader) (obj *ObjectFile, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if IsTextObject(data) {
+		return ReadText(bytes.NewReader(data))
+	}
 	obj = &ObjectFile{}
 
 	// header
-	if err := binary.Read(r, binary.BigEndian, &obj.Header); err != nil {
+	if err := readHeader(data, &obj.Header); err != nil {
 		return nil, err
 	}
-	obj.StringTable = make([]byte, obj.Header.StringTabSize)
+	header := &obj.Header
+	// string table, first so names can be checked
+	strtab, err := slice(data, uint64(header.StringOffset), uint64(header.StringTabSize), "string table")
+	if err != nil {
+		return nil, err
+	}
+	obj.StringTable = slices.Clone(strtab)
 	// section headers
-	for range obj.Header.SectionCount {
+	for idx := range uint64(header.SectionCount) {
 		section := Section{}
-		if err := binary.Read(r, binary.BigEndian, &section.Header); err != nil {
+		what := fmt.Sprintf("section %d header", idx)
+		off := uint64(header.SectionOffset) + idx*uint64(sectionHeaderSize)
+		if err := decode(data, off, sectionHeaderSize, what, &section.Header); err != nil {
+			return nil, err
+		}
+		if section.Name, err = obj.nameAt(section.Header.NameOffset, what, off); err != nil {
+			return nil, err
+		}
+		if section.Header.Size%2 != 0 {
+			return nil, &FormatError{Err: SectionSizeErr, What: what, Offset: int64(off),
+				Detail: fmt.Sprintf("%d bytes", section.Header.Size)}
+		}
+		if section.Header.Type == SHT_NOBITS {
+			obj.Sections = append(obj.Sections, section)
+			continue // takes memory, not file space
+		}
+		// section data
+		words, err := slice(data, uint64(section.Header.Offset), uint64(section.Header.Size),
+			fmt.Sprintf("section %d (%s) data", idx, section.Name))
+		if err != nil {
 			return nil, err
 		}
+		for i := 0; i < len(words); i += 2 {
+			section.Data = append(section.Data, binary.BigEndian.Uint16(words[i:]))
+		}
 		obj.Sections = append(obj.Sections, section)
 	}
 	// symbols
-	for range obj.Header.SymbolCount {
+	for idx := range uint64(header.SymbolCount) {
 		symbol := Symbol{}
-		if err := binary.Read(r, binary.BigEndian, &symbol); err != nil {
+		what := fmt.Sprintf("symbol %d", idx)
+		off := uint64(header.SymbolOffset) + idx*uint64(symbolSize)
+		if err := decode(data, off, symbolSize, what, &symbol); err != nil {
 			return nil, err
 		}
+		if _, err := obj.nameAt(symbol.NameOffset, what, off); err != nil {
+			return nil, err
+		}
+		if symbol.Section != SHN_UNDEF && symbol.Section != SHN_ABS && symbol.Section >= header.SectionCount {
+			return nil, &FormatError{Err: SectionIndexErr, What: what, Offset: int64(off),
+				Detail: fmt.Sprintf("section %d of %d", symbol.Section, header.SectionCount)}
+		}
 		obj.Symbols = append(obj.Symbols, symbol)
 	}
 	// relocations
-	for range obj.Header.RelocCount {
+	for idx := range uint64(header.RelocCount) {
 		reloc := Relocation{}
-		if err := binary.Read(r, binary.BigEndian, &reloc.Offset); err != nil {
+		what := fmt.Sprintf("relocation %d", idx)
+		off := uint64(header.RelocOffset) + idx*uint64(relocationSize)
+		if err := decode(data, off, relocationSize, what, &reloc); err != nil {
 			return nil, err
 		}
-		if err := binary.Read(r, binary.BigEndian, &reloc.Info); err != nil {
-			return nil, err
+		if reloc.SymbolIndex() >= uint32(header.SymbolCount) {
+			return nil, &FormatError{Err: SymbolIndexErr, What: what, Offset: int64(off),
+				Detail: fmt.Sprintf("symbol %d of %d", reloc.SymbolIndex(), header.SymbolCount)}
 		}
-		if err := binary.Read(r, binary.BigEndian, &reloc.Addend); err != nil {
-			return nil, err
+		if reloc.Section >= header.SectionCount {
+			return nil, &FormatError{Err: SectionIndexErr, What: what, Offset: int64(off),
+				Detail: fmt.Sprintf("section %d of %d", reloc.Section, header.SectionCount)}
+		}
+		if target := obj.Sections[reloc.Section]; reloc.Offset >= dubcc.MachineAddress(len(target.Data)) {
+			return nil, &FormatError{Err: OffsetRangeErr, What: what, Offset: int64(off),
+				Detail: fmt.Sprintf("word %d of %s, which has %d words", reloc.Offset, target.Name, len(target.Data))}
 		}
 		obj.Relocations = append(obj.Relocations, reloc)
 	}
-	// string table
-	if _, err := r.Read(obj.StringTable); err != nil {
-		return nil, err
+
+	return obj, nil
+}
+
+// Checks magic and version before trusting anything else in the header.
+// Headers from newer writers may be longer, the tables are found through
+// their offsets anyway.
+func readHeader(data []byte, header *DulfHeader) error {
+	if len(data) < 4 || [4]byte(data[:4]) != DulfMagic {
+		return &FormatError{Err: BadMagicErr, What: "header",
+			Detail: fmt.Sprintf("magic %q", data[:min(len(data), 4)])}
 	}
-	// section data
-	for idx, section := range obj.Sections {
-		obj.Sections[idx].Name = obj.GetString(section.Header.NameOffset)
-		for range (section.Header.Size/2) {
-			var word dubcc.MachineWord
-			if err := binary.Read(r, binary.BigEndian, &word); err != nil {
-				return nil, err
-			}
-			obj.Sections[idx].Data = append(obj.Sections[idx].Data, word)
+	if len(data) >= 6 {
+		if version := binary.BigEndian.Uint16(data[4:6]); version != DULF_VERSION {
+			return &FormatError{Err: VersionErr, What: "header", Offset: 4,
+				Detail: fmt.Sprintf("version %d, expected %d; reassemble the file", version, DULF_VERSION)}
 		}
 	}
+	if err := decode(data, 0, headerSize, "header", header); err != nil {
+		return err
+	}
+	if uint32(header.HeaderSize) < headerSize {
+		return &FormatError{Err: OffsetRangeErr, What: "header", Offset: 6,
+			Detail: fmt.Sprintf("header size %d is smaller than %d", header.HeaderSize, headerSize)}
+	}
+	return nil
+}
 
-	return obj, nil
+// data[off:off+size], or an error saying which part of the file is missing
+func slice(data []byte, off uint64, size uint64, what string) ([]byte, error) {
+	switch {
+	case off > uint64(len(data)):
+		return nil, &FormatError{Err: OffsetRangeErr, What: what, Offset: int64(off),
+			Detail: fmt.Sprintf("file has %d bytes", len(data))}
+	case size > uint64(len(data))-off:
+		return nil, &FormatError{Err: TruncatedErr, What: what, Offset: int64(off),
+			Detail: fmt.Sprintf("needs %d bytes, file has %d", size, uint64(len(data))-off)}
+	}
+	return data[off : off+size], nil
+}
+
+func decode(data []byte, off uint64, size uint32, what string, v any) error {
+	buf, err := slice(data, off, uint64(size), what)
+	if err != nil {
+		return err
+	}
+	return binary.Read(bytes.NewReader(buf), binary.BigEndian, v)
+}
+
+func (obj *ObjectFile) nameAt(offset uint32, what string, off uint64) (string, error) {
+	name, err := obj.StringAt(offset)
+	if err != nil {
+		return "", &FormatError{Err: OffsetRangeErr, What: what + " name", Offset: int64(off), Detail: err.Error()}
+	}
+	return name, nil
 }
 
 func (obj *ObjectFile) PrettyPrint() string {
@@ -0,0 +1,255 @@
+package assembler
+
+import (
+	"bytes"
+	"dubcc"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+const sampleSource = `br start
+double: extdef
+extr main
+data
+value: const 21
+bss
+result: space
+text
+start: load value
+main: call double
+store result
+write ACC
+stop
+end
+`
+
+func assembleSample(t testing.TB) *ObjectFile {
+	info := MakeAssembler()
+	for idx, line := range bytes.Split([]byte(sampleSource), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		info.FirstPassSource(dubcc.SourceLine{File: "sample.asm", Line: idx + 1, Text: string(line)})
+	}
+	if _, err := info.SecondPass(); err != nil {
+		t.Fatalf("assembling sample: %v", err)
+	}
+	obj, err := info.GenerateObjectFile()
+	if err != nil {
+		t.Fatalf("generating object: %v", err)
+	}
+	return obj
+}
+
+func encode(t testing.TB, obj *ObjectFile) []byte {
+	var buf bytes.Buffer
+	if err := obj.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Builds an object with random contents that Write can encode
+func randomObject(rng *rand.Rand) *ObjectFile {
+	obj := &ObjectFile{StringMap: make(map[string]uint32)}
+	obj.AddString("")
+	obj.Header.Kind = ObjectKind(rng.IntN(4))
+	if rng.IntN(2) == 0 {
+		obj.SetEntry(rng.Uint64N(1 << 16))
+	}
+
+	names := []string{".text", ".data", "main", "loop", "x", "a.long.name"}
+	for range rng.IntN(4) {
+		section := Section{Name: names[rng.IntN(2)]}
+		for range rng.IntN(20) {
+			section.Data = append(section.Data, dubcc.MachineWord(rng.Uint32()))
+		}
+		section.Header = SectionHeader{
+			NameOffset: obj.AddString(section.Name),
+			Type:       DulfSection(rng.IntN(5)),
+			Flags:      rng.Uint32(),
+			Address:    rng.Uint64N(1 << 16),
+			Size:       uint32(len(section.Data) * 2),
+		}
+		if section.Header.Type == SHT_NOBITS {
+			section.Data = nil
+		}
+		obj.Sections = append(obj.Sections, section)
+	}
+	for range rng.IntN(6) {
+		symbol := Symbol{
+			NameOffset: obj.AddString(names[rng.IntN(len(names))]),
+			Value:      rng.Uint64N(1 << 16),
+			Size:       rng.Uint32(),
+			Section:    SHN_UNDEF,
+		}
+		if len(obj.Sections) > 0 {
+			symbol.Section = uint16(rng.IntN(len(obj.Sections)))
+		}
+		symbol.SetInfo(SymbolBinding(rng.IntN(2)), SymbolType(rng.IntN(4)))
+		obj.Symbols = append(obj.Symbols, symbol)
+	}
+	for range rng.IntN(6) {
+		if len(obj.Symbols) == 0 || len(obj.Sections) == 0 {
+			break
+		}
+		section := rng.IntN(len(obj.Sections))
+		if len(obj.Sections[section].Data) == 0 {
+			continue
+		}
+		reloc := Relocation{
+			Offset:  rng.Uint64N(uint64(len(obj.Sections[section].Data))),
+			Addend:  rng.Int64N(100) - 50,
+			Section: uint16(section),
+		}
+		reloc.SetInfo(uint32(rng.IntN(len(obj.Symbols))), R_ABSOLUTE)
+		obj.Relocations = append(obj.Relocations, reloc)
+	}
+	obj.Header.SectionCount = uint16(len(obj.Sections))
+	obj.Header.SymbolCount = uint16(len(obj.Symbols))
+	obj.Header.RelocCount = uint16(len(obj.Relocations))
+	return obj
+}
+
+func sameObject(t testing.TB, want, got *ObjectFile) {
+	t.Helper()
+	if want.Header != got.Header {
+		t.Fatalf("header differs:\nwant %+v\ngot  %+v", want.Header, got.Header)
+	}
+	if !bytes.Equal(want.StringTable, got.StringTable) {
+		t.Fatalf("string table differs:\nwant %q\ngot  %q", want.StringTable, got.StringTable)
+	}
+	if len(want.Sections) != len(got.Sections) {
+		t.Fatalf("want %d sections, got %d", len(want.Sections), len(got.Sections))
+	}
+	for idx := range want.Sections {
+		w, g := want.Sections[idx], got.Sections[idx]
+		if w.Name != g.Name || w.Header != g.Header || !reflect.DeepEqual(append([]dubcc.MachineWord{}, w.Data...), append([]dubcc.MachineWord{}, g.Data...)) {
+			t.Fatalf("section %d differs:\nwant %+v\ngot  %+v", idx, w, g)
+		}
+	}
+	if !reflect.DeepEqual(want.Symbols, got.Symbols) {
+		t.Fatalf("symbols differ:\nwant %+v\ngot  %+v", want.Symbols, got.Symbols)
+	}
+	if !reflect.DeepEqual(want.Relocations, got.Relocations) {
+		t.Fatalf("relocations differ:\nwant %+v\ngot  %+v", want.Relocations, got.Relocations)
+	}
+}
+
+func TestRoundTripSample(t *testing.T) {
+	obj := assembleSample(t)
+	data := encode(t, obj)
+	got, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	sameObject(t, obj, got)
+	if !bytes.Equal(data, encode(t, got)) {
+		t.Fatal("writing the object read back gives different bytes")
+	}
+}
+
+func TestRoundTripRandom(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 500 {
+		obj := randomObject(rng)
+		data := encode(t, obj)
+		got, err := Read(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Read of %+v: %v", obj, err)
+		}
+		sameObject(t, obj, got)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	valid := encode(t, assembleSample(t))
+	withHeader := func(mutate func(h *DulfHeader)) []byte {
+		var h DulfHeader
+		if err := binary.Read(bytes.NewReader(valid), binary.BigEndian, &h); err != nil {
+			t.Fatal(err)
+		}
+		mutate(&h)
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.BigEndian, h)
+		return append(buf.Bytes(), valid[buf.Len():]...)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, BadMagicErr},
+		{"magic", append([]byte("ELF\x7f"), valid[4:]...), BadMagicErr},
+		{"version", append(append([]byte("DULF"), 0, 1), valid[6:]...), VersionErr},
+		{"header", valid[:20], TruncatedErr},
+		{"truncated data", valid[:len(valid)-1], TruncatedErr},
+		{"string table", withHeader(func(h *DulfHeader) { h.StringOffset = 1 << 20 }), OffsetRangeErr},
+		{"symbols", withHeader(func(h *DulfHeader) { h.SymbolOffset = uint32(len(valid) - 5) }), TruncatedErr},
+		{"symbol name", func() []byte {
+			out := bytes.Clone(valid)
+			obj, _ := Read(bytes.NewReader(valid))
+			out[obj.Header.SymbolOffset] = 0xff // NameOffset high byte
+			return out
+		}(), OffsetRangeErr},
+		{"relocation symbol", func() []byte {
+			out := bytes.Clone(valid)
+			obj, _ := Read(bytes.NewReader(valid))
+			out[obj.Header.RelocOffset+8] = 0xff // Info high byte
+			return out
+		}(), SymbolIndexErr},
+		{"relocation section", func() []byte {
+			out := bytes.Clone(valid)
+			obj, _ := Read(bytes.NewReader(valid))
+			out[obj.Header.RelocOffset+20] = 0xff // Section high byte
+			return out
+		}(), SectionIndexErr},
+		{"odd section", func() []byte {
+			out := bytes.Clone(valid)
+			obj, _ := Read(bytes.NewReader(valid))
+			out[obj.Header.SectionOffset+27] |= 1 // Size low byte
+			return out
+		}(), SectionSizeErr},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Read(bytes.NewReader(test.data))
+			if !errors.Is(err, test.want) {
+				t.Fatalf("want %v, got %v", test.want, err)
+			}
+			var formatErr *FormatError
+			if !errors.As(err, &formatErr) {
+				t.Fatalf("%v is not a *FormatError", err)
+			}
+		})
+	}
+}
+
+func FuzzRead(f *testing.F) {
+	f.Add(encode(f, assembleSample(f)))
+	f.Add([]byte(encodeText(f, assembleSample(f))))
+	rng := rand.New(rand.NewPCG(3, 4))
+	for range 8 {
+		f.Add(encode(f, randomObject(rng)))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		obj, err := Read(bytes.NewReader(data))
+		if err != nil {
+			var formatErr *FormatError
+			if !errors.As(err, &formatErr) {
+				t.Fatalf("Read error %v is not a *FormatError", err)
+			}
+			return
+		}
+		// anything Read accepts must survive a Write/Read round trip
+		again, err := Read(bytes.NewReader(encode(t, obj)))
+		if err != nil {
+			t.Fatalf("reading back a written object: %v", err)
+		}
+		sameObject(t, obj, again)
+	})
+}
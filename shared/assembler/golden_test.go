@@ -0,0 +1,68 @@
+package assembler_test
+
+import (
+	"bytes"
+	"dubcc/assembler"
+	"dubcc/macroprocessor"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the objects/*.o.txt golden files")
+
+func assembleSource(name, source string) (*assembler.ObjectFile, error) {
+	info, diags, err := macroprocessor.AssembleSource(name, source)
+	if err != nil {
+		return nil, fmt.Errorf("assembling %s: %v", name, diags)
+	}
+	return info.GenerateObjectFile()
+}
+
+// Every sample assembles to the text object checked in under objects/.
+// After changing the assembler on purpose, run go test -update and review
+// the diff.
+func TestGoldenObjects(t *testing.T) {
+	log.SetOutput(io.Discard)
+	samples, err := filepath.Glob("../../samples/*.asm")
+	if err != nil || len(samples) == 0 {
+		t.Fatalf("no samples: %v", err)
+	}
+	for _, sample := range samples {
+		name := strings.TrimSuffix(filepath.Base(sample), ".asm")
+		t.Run(name, func(t *testing.T) {
+			source, err := os.ReadFile(sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			obj, err := assembleSource(name+".asm", string(source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			if err := obj.WriteText(&got); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("../../objects", name+".o"+assembler.TextObjectExt)
+			if *update {
+				if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (go test -update writes it)", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("%s differs from the assembler output:\n%s", golden, got.String())
+			}
+		})
+	}
+}
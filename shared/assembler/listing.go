@@ -0,0 +1,145 @@
+package assembler
+
+import (
+	"dubcc"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Uma linha da listagem: a linha fonte e as palavras que ela gerou
+type ListingEntry struct {
+	Source  dubcc.SourceLine
+	Offset  dubcc.MachineAddress // in the section the line was assembled into
+	Size    int                  // words generated (or reserved) by the line
+	section *asmSection
+}
+
+func (entry ListingEntry) Section() string {
+	return entry.section.name
+}
+
+// Address of the line's first word, final after SecondPass
+func (entry ListingEntry) Address() dubcc.MachineAddress {
+	return entry.section.address + entry.Offset
+}
+
+const listingWordsPerRow = 3
+
+func (info *Info) Listing() []ListingEntry {
+	return info.listing
+}
+
+// Writes a classic assembler listing: address, generated words, relocation
+// markers (R relocatable, E external) and source, followed by the symbol
+// cross-reference table. Call it after SecondPass so references are patched.
+func (info *Info) WriteListing(w io.Writer) error {
+	refs := make(map[location]string) // operand -> symbol
+	for sym, froms := range info.symbolOccurances {
+		for _, from := range froms {
+			refs[from] = sym
+		}
+	}
+	lineOf := make(map[location]int) // word -> source line
+	for _, entry := range info.listing {
+		for i := range entry.Size {
+			lineOf[location{entry.section, entry.Offset + dubcc.MachineAddress(i)}] = entry.Source.Line
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "DUBcc assembler listing: %s\n\n", info.listingName())
+	fmt.Fprintf(&b, "%5s  %4s  %-18s  %s\n", "LINE", "ADDR", "CODE", "SOURCE")
+	for _, entry := range info.listing {
+		words := []string{}
+		for i := range dubcc.MachineAddress(entry.Size) {
+			if entry.section.kind == SHT_NOBITS {
+				break // reserved space, no code
+			}
+			at := location{entry.section, entry.Offset + i}
+			words = append(words, fmt.Sprintf("%04x", entry.section.output[at.offset])+info.relocMarker(refs[at]))
+		}
+		source := strings.TrimRight(entry.Source.Text, " \t\r")
+		if entry.Source.Macro != "" {
+			source = fmt.Sprintf("+ %-30s ; from macro %s", strings.TrimSpace(source), entry.Source.Macro)
+		}
+
+		addr := fmt.Sprintf("%04x", entry.Address())
+		if entry.Size == 0 {
+			addr = ""
+		}
+		for row := 0; row == 0 || row*listingWordsPerRow < len(words); row++ {
+			chunk := words[min(row*listingWordsPerRow, len(words)):min((row+1)*listingWordsPerRow, len(words))]
+			if row == 0 {
+				fmt.Fprintf(&b, "%5d  %4s  %-18s  %s\n", entry.Source.Line, addr, strings.Join(chunk, " "), source)
+			} else {
+				rowAddr := entry.Address() + dubcc.MachineAddress(row*listingWordsPerRow)
+				fmt.Fprintf(&b, "%5s  %04x  %-18s\n", "", rowAddr, strings.Join(chunk, " "))
+			}
+		}
+	}
+
+	fmt.Fprintf(&b, "\nSYMBOL TABLE\n\n")
+	fmt.Fprintf(&b, "%-20s  %5s  %-8s  %-7s  %7s  %s\n", "NAME", "VALUE", "SECTION", "BIND", "DEFINED", "REFERENCED")
+	names := slices.Collect(maps.Keys(info.symbols))
+	names = append(names, info.ExternalSymbols()...)
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		value, section := "----", "*UND*"
+		if addr, found := info.SymbolAddress(name); found {
+			value, section = fmt.Sprintf("%04x", addr), info.symbolSections[name].name
+		}
+		bind := "local"
+		if info.IsExternalSymbol(name) {
+			bind = "extern"
+		} else if info.IsGlobalSymbol(name) {
+			bind = "global"
+		}
+		defined := "-"
+		if def, found := info.symbolDefs[name]; found {
+			defined = fmt.Sprint(def.Line)
+		}
+		referenced := []string{}
+		for _, from := range info.symbolOccurances[name] {
+			referenced = append(referenced, fmt.Sprint(lineOf[from]))
+		}
+		fmt.Fprintf(&b, "%-20s  %5s  %-8s  %-7s  %7s  %s\n", name, value, section, bind, defined, strings.Join(referenced, " "))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (info *Info) relocMarker(sym string) string {
+	switch {
+	case sym == "":
+		return " "
+	case info.IsExternalSymbol(sym):
+		return "E"
+	default:
+		return "R"
+	}
+}
+
+func (info *Info) listingName() string {
+	if info.file != "" {
+		return info.file
+	}
+	if len(info.listing) > 0 && info.listing[0].Source.File != "" {
+		return info.listing[0].Source.File
+	}
+	return "<input>"
+}
+
+func SaveListingFile(info *Info, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return info.WriteListing(file)
+}
@@ -0,0 +1,119 @@
+package assembler_test
+
+import (
+	"dubcc/assembler"
+	"dubcc/macroprocessor"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const listingSource = `MACRO
+ADD2 X
+add X
+add X
+MEND
+extr main
+putc: extdef
+main: load n
+ADD2 n
+call putc
+br done
+data
+n: const 5
+tbl: const 1
+const 2
+bss
+buf: space
+text
+done: stop
+end
+`
+
+// 004b in the call is the link the undefined chain left for putc, the
+// linker replaces it
+var listingWant = `DUBcc assembler listing: list.asm
+
+ LINE  ADDR  CODE                SOURCE
+    6                            extr main
+    7                            putc: extdef
+    8  0000  0003  000bR         main: load n
+    9  0002  0002  000bR         + add n                          ; from macro ADD2
+    9  0004  0002  000bR         + add n                          ; from macro ADD2
+   10  0006  000f  004bE         call putc
+   11  0008  0000  000aR         br done
+   12                            data
+   13  000b  0005                n: const 5
+   14  000c  0001                tbl: const 1
+   15  000d  0002                const 2
+   16                            bss
+   17  000e                      buf: space
+   18                            text
+   19  000a  000b                done: stop
+   20                            end
+
+SYMBOL TABLE
+
+NAME                  VALUE  SECTION   BIND     DEFINED  REFERENCED
+buf                    000e  .bss      local         17
+done                   000a  .text     local         19  11
+main                   0000  .text     global         8
+n                      000b  .data     local         13  8 9 9
+putc                   ----  *UND*     extern         7  10
+tbl                    000c  .data     local         14
+`
+
+// Trailing blanks of the padded columns don't matter
+func listingLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return lines
+}
+
+func TestWriteListing(t *testing.T) {
+	log.SetOutput(io.Discard)
+	info, diags, err := macroprocessor.AssembleSource("list.asm", listingSource)
+	if err != nil {
+		t.Fatal(diags)
+	}
+
+	var b strings.Builder
+	if err := info.WriteListing(&b); err != nil {
+		t.Fatal(err)
+	}
+	got, want := listingLines(b.String()), listingLines(listingWant)
+	if !slices.Equal(got, want) {
+		for i := range min(len(got), len(want)) {
+			if got[i] != want[i] {
+				t.Errorf("line %d: got %q, want %q", i+1, got[i], want[i])
+			}
+		}
+		t.Fatalf("listing:\n%s", b.String())
+	}
+
+	// the entries behind the rows
+	var macroRows []string
+	for _, entry := range info.Listing() {
+		if entry.Source.Macro != "" {
+			macroRows = append(macroRows, fmt.Sprintf("%s %04x %d %s", entry.Section(), entry.Address(), entry.Size, entry.Source.Text))
+		}
+	}
+	if want := []string{".text 0002 2 add n", ".text 0004 2 add n"}; !slices.Equal(macroRows, want) {
+		t.Errorf("macro rows %q, want %q", macroRows, want)
+	}
+
+	filename := filepath.Join(t.TempDir(), "list.lst")
+	if err := assembler.SaveListingFile(info, filename); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := os.ReadFile(filename); err != nil || string(saved) != b.String() {
+		t.Errorf("saved listing differs from the written one: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package assembler_test
+
+import (
+	"dubcc/assembler"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+// Every operand is checked against the modes its instruction allows
+func TestOperandModes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	tests := []struct {
+		line string
+		err  string // "" if the line assembles
+	}{
+		{"copy x 20", ""},
+		{"copy ACC 7", ""},
+		{"load x,I", ""},
+		{"br l,R", ""},
+		{"copy 30 20", "copy: operand 1 (30) can't be immediate"},
+		{"store 5", "store: operand 1 (5) can't be immediate"},
+		{"read 7", "read: operand 1 (7) can't be immediate"},
+		{"br ACC", "br: operand 1 (ACC) can't be register"},
+		{"load ACC,I", "indirect operand ACC,I must be a memory address"},
+		{"br 3,R", "relative operand 3,R must be a label"},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			info := assembler.MakeAssembler()
+			info.SetFile("modes.asm")
+			for _, line := range []string{"l: " + test.line, "stop", "x: space", "end"} {
+				info.FirstPassString(line)
+			}
+			info.SecondPass()
+
+			diags := info.Diagnostics()
+			if test.err == "" {
+				if diags.HasErrors() {
+					t.Fatalf("rejected: %v", diags)
+				}
+				return
+			}
+			if !diags.HasErrors() || !strings.Contains(diags.Error(), test.err) {
+				t.Fatalf("want an error %q, got %v", test.err, diags)
+			}
+			if diags[0].Line != 1 {
+				t.Errorf("error on line %d, want 1", diags[0].Line)
+			}
+		})
+	}
+}
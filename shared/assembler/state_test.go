@@ -0,0 +1,70 @@
+package assembler_test
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// All of an assembly's state lives in its Info: assembling every sample
+// again, or many at once, gives the same bytes.
+func TestIndependentAssemblies(t *testing.T) {
+	log.SetOutput(io.Discard)
+	samples, err := filepath.Glob("../../samples/*.asm")
+	if err != nil || len(samples) == 0 {
+		t.Fatalf("no samples: %v", err)
+	}
+	sources := make([]string, len(samples))
+	for i, sample := range samples {
+		source, err := os.ReadFile(sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[i] = string(source)
+	}
+	encode := func(i int) ([]byte, error) {
+		obj, err := assembleSource(filepath.Base(samples[i]), sources[i])
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		err = obj.Write(&buf)
+		return buf.Bytes(), err
+	}
+
+	want := make([][]byte, len(samples))
+	for i := range samples {
+		if want[i], err = encode(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, sample := range samples {
+		if again, err := encode(i); err != nil || !bytes.Equal(again, want[i]) {
+			t.Errorf("%s: second assembly differs (%v)", sample, err)
+		}
+	}
+
+	const rounds = 4
+	var wg sync.WaitGroup
+	errs := make(chan string, rounds*len(samples))
+	for range rounds {
+		for i, sample := range samples {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if got, err := encode(i); err != nil || !bytes.Equal(got, want[i]) {
+					errs <- sample
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for sample := range errs {
+		t.Errorf("%s: concurrent assembly differs", sample)
+	}
+}
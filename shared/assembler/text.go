@@ -0,0 +1,523 @@
+package assembler
+
+import (
+	"bufio"
+	"bytes"
+	"dubcc"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Text form of a DULF file, for golden files and diffs. Nothing is lost:
+// the binary written from it is the same as the one it came from. One
+// record per line, ';' starts a comment:
+//
+//	DULF text 3
+//	kind rel
+//	flags 0x0001 ; entry
+//	entry 0x0000
+//	string ""
+//	string ".text"
+//	section ".text" type progbits flags 0x00000006 address 0x0000 words 3 link 0 info 0 align 0
+//		0203 0003 000b
+//	symbol "main" section 0 value 0x0000 size 3 bind local type func other 0
+//	reloc section 0 offset 0x0001 type absolute symbol 0 addend 0 ; main
+//
+// Names are quoted and refer to their string. A name that doesn't start at
+// the first copy of its string is written as @offset. Text files get
+// TextObjectExt after the usual extension: file.o.txt, prog.hpx.txt.
+const TextObjectExt = ".txt"
+
+var textMagic = "DULF text"
+
+var TextSyntaxErr = errors.New("bad text object")
+
+var (
+	kindNames        = []string{"none", "rel", "exec", "lib"}
+	sectionTypeNames = []string{"progbits", "symtab", "strtab", "rela", "nobits"}
+	bindingNames     = []string{"local", "global"}
+	symbolTypeNames  = []string{"notype", "data", "func", "section"}
+	relocTypeNames   = []string{"", "absolute", "relative"}
+)
+
+const textWordsPerLine = 8
+
+func IsTextObject(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(textMagic))
+}
+
+// Whether filename should hold a text object
+func IsTextObjectName(filename string) bool {
+	return strings.HasSuffix(filename, TextObjectExt)
+}
+
+func enumName[T ~uint8 | ~uint16 | ~uint32](value T, names []string) string {
+	if int(value) < len(names) && names[value] != "" {
+		return names[value]
+	}
+	return strconv.FormatUint(uint64(value), 10)
+}
+
+func (obj *ObjectFile) WriteText(w io.Writer) error {
+	obj.layout()
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s %d\n", textMagic, obj.Header.Version)
+	fmt.Fprintf(&b, "kind %s\n", enumName(obj.Header.Kind, kindNames))
+	fmt.Fprintf(&b, "flags 0x%04x", obj.Header.Flags)
+	var flags []string
+	if obj.Header.Flags&DF_ENTRY != 0 {
+		flags = append(flags, "entry")
+	}
+	if obj.Header.Flags&DF_RELOCATABLE != 0 {
+		flags = append(flags, "relocatable")
+	}
+	if len(flags) > 0 {
+		fmt.Fprintf(&b, " ; %s", strings.Join(flags, ", "))
+	}
+	fmt.Fprintf(&b, "\nentry 0x%04x\n", obj.Header.Entry)
+
+	table := obj.StringTable
+	for len(table) > 0 {
+		end := bytes.IndexByte(table, 0)
+		if end < 0 {
+			fmt.Fprintf(&b, "tail %s\n", strconv.Quote(string(table)))
+			break
+		}
+		fmt.Fprintf(&b, "string %s\n", strconv.Quote(string(table[:end])))
+		table = table[end+1:]
+	}
+
+	for _, section := range obj.Sections {
+		header := section.Header
+		fmt.Fprintf(&b, "section %s type %s flags 0x%08x address 0x%04x words %d link %d info %d align %d",
+			obj.textName(header.NameOffset), enumName(header.Type, sectionTypeNames), header.Flags,
+			header.Address, header.Size/2, header.Link, header.Info, header.Alignment)
+		fmt.Fprintf(&b, "\n")
+		for row := 0; row < len(section.Data); row += textWordsPerLine {
+			var words []string
+			for _, word := range section.Data[row:min(row+textWordsPerLine, len(section.Data))] {
+				words = append(words, fmt.Sprintf("%04x", word))
+			}
+			fmt.Fprintf(&b, "\t%s\n", strings.Join(words, " "))
+		}
+	}
+
+	for _, symbol := range obj.Symbols {
+		section := strconv.Itoa(int(symbol.Section))
+		switch symbol.Section {
+		case SHN_UNDEF:
+			section = "undef"
+		case SHN_ABS:
+			section = "abs"
+		}
+		fmt.Fprintf(&b, "symbol %s section %s value 0x%04x size %d bind %s type %s other %d\n",
+			obj.textName(symbol.NameOffset), section, symbol.Value, symbol.Size,
+			enumName(symbol.Info>>4, bindingNames), enumName(symbol.Type(), symbolTypeNames), symbol.Other)
+	}
+
+	for _, reloc := range obj.Relocations {
+		fmt.Fprintf(&b, "reloc section %d offset 0x%04x type %s symbol %d addend %d",
+			reloc.Section, reloc.Offset, enumName(reloc.RelocType(), relocTypeNames),
+			reloc.SymbolIndex(), reloc.Addend)
+		if idx := reloc.SymbolIndex(); int(idx) < len(obj.Symbols) {
+			fmt.Fprintf(&b, " ; %s", obj.GetString(obj.Symbols[idx].NameOffset))
+		}
+		fmt.Fprintf(&b, "\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// The quoted name at offset, or @offset when quoting it wouldn't read back
+// to the same offset
+func (obj *ObjectFile) textName(offset uint32) string {
+	name, err := obj.StringAt(offset)
+	if err == nil && obj.stringOffset(name) == offset {
+		return strconv.Quote(name)
+	}
+	return fmt.Sprintf("@%d", offset)
+}
+
+// Offset of the first string of the table equal to name, or -1
+func (obj *ObjectFile) stringOffset(name string) uint32 {
+	for offset := 0; offset < len(obj.StringTable); {
+		end := bytes.IndexByte(obj.StringTable[offset:], 0)
+		if end < 0 {
+			break
+		}
+		if string(obj.StringTable[offset:offset+end]) == name {
+			return uint32(offset)
+		}
+		offset += end + 1
+	}
+	return ^uint32(0)
+}
+
+// Reads a text object, validating it like Read does
+func ReadText(r io.Reader) (*ObjectFile, error) {
+	obj := &ObjectFile{}
+	p := textParser{obj: obj}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		p.line++
+		if err := p.parseLine(scanner.Text()); err != nil {
+			return nil, err
+		}
+		p.offset += int64(len(scanner.Bytes())) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !p.seenMagic {
+		return nil, &FormatError{Err: BadMagicErr, What: "header", Detail: fmt.Sprintf("no %q line", textMagic)}
+	}
+
+	// names, now that the string table is complete
+	for _, ref := range p.names {
+		offset := obj.stringOffset(ref.name)
+		if offset == ^uint32(0) {
+			return nil, p.errorAt(ref.at, OffsetRangeErr, "name %q is not in the string table", ref.name)
+		}
+		*ref.offset = offset
+	}
+	for idx, section := range p.sections {
+		var err error
+		if section.Name, err = obj.StringAt(section.Header.NameOffset); err != nil {
+			return nil, p.errorAt(p.sectionLines[idx], OffsetRangeErr, "%v", err)
+		}
+		if section.Header.Type == SHT_NOBITS && len(section.Data) > 0 {
+			return nil, p.errorAt(p.sectionLines[idx], TextSyntaxErr, "nobits section %s has data", section.Name)
+		}
+		if words := int(section.Header.Size / 2); section.Header.Type != SHT_NOBITS && len(section.Data) != words {
+			return nil, p.errorAt(p.sectionLines[idx], TextSyntaxErr,
+				"%d words of data, words says %d", len(section.Data), words)
+		}
+		obj.Sections = append(obj.Sections, *section)
+	}
+	for idx, symbol := range p.symbols {
+		if _, err := obj.StringAt(symbol.NameOffset); err != nil {
+			return nil, p.errorAt(p.symbolLines[idx], OffsetRangeErr, "%v", err)
+		}
+		obj.Symbols = append(obj.Symbols, *symbol)
+	}
+
+	obj.Header.SectionCount = uint16(len(obj.Sections))
+	obj.Header.SymbolCount = uint16(len(obj.Symbols))
+	obj.Header.RelocCount = uint16(len(obj.Relocations))
+	for idx, symbol := range obj.Symbols {
+		if symbol.Section != SHN_UNDEF && symbol.Section != SHN_ABS && symbol.Section >= obj.Header.SectionCount {
+			return nil, p.errorAt(p.symbolLines[idx], SectionIndexErr,
+				"section %d of %d", symbol.Section, obj.Header.SectionCount)
+		}
+	}
+	for idx, reloc := range obj.Relocations {
+		switch {
+		case reloc.SymbolIndex() >= uint32(obj.Header.SymbolCount):
+			return nil, p.errorAt(p.relocLines[idx], SymbolIndexErr,
+				"symbol %d of %d", reloc.SymbolIndex(), obj.Header.SymbolCount)
+		case reloc.Section >= obj.Header.SectionCount:
+			return nil, p.errorAt(p.relocLines[idx], SectionIndexErr,
+				"section %d of %d", reloc.Section, obj.Header.SectionCount)
+		case reloc.Offset >= dubcc.MachineAddress(len(obj.Sections[reloc.Section].Data)):
+			target := obj.Sections[reloc.Section]
+			return nil, p.errorAt(p.relocLines[idx], OffsetRangeErr,
+				"word %d of %s, which has %d words", reloc.Offset, target.Name, len(target.Data))
+		}
+	}
+	obj.layout()
+	return obj, nil
+}
+
+// Line number and file offset of a record, for errors
+type textPos = [2]int64
+
+type textParser struct {
+	obj       *ObjectFile
+	line      int
+	offset    int64 // of the line in the file
+	seenMagic bool
+	inData    bool       // data lines go to the last section
+	sections  []*Section // copied to obj once their names are known
+	symbols   []*Symbol
+	names     []textNameRef
+
+	sectionLines, symbolLines, relocLines []textPos
+}
+
+// A quoted name waiting for the string table to be complete
+type textNameRef struct {
+	name   string
+	offset *uint32
+	at     textPos
+}
+
+func (p *textParser) pos() textPos {
+	return textPos{int64(p.line), p.offset}
+}
+
+func (p *textParser) errorAt(at textPos, err error, format string, args ...any) error {
+	return &FormatError{Err: err, What: fmt.Sprintf("line %d", at[0]), Offset: at[1],
+		Detail: fmt.Sprintf(format, args...)}
+}
+
+func (p *textParser) errorf(format string, args ...any) error {
+	return p.errorAt(p.pos(), TextSyntaxErr, format, args...)
+}
+
+// Splits a line into fields, quoted strings kept whole (with the quotes)
+func (p *textParser) fields(line string) ([]string, error) {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		switch {
+		case line == "" || line[0] == ';':
+			return fields, nil
+		case line[0] == '"':
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, p.errorf("unterminated string %s", line)
+			}
+			fields = append(fields, quoted)
+			line = line[len(quoted):]
+		default:
+			end := strings.IndexAny(line, " \t;\"")
+			if end < 0 {
+				end = len(line)
+			}
+			fields = append(fields, line[:end])
+			line = line[end:]
+		}
+	}
+}
+
+func (p *textParser) parseLine(line string) error {
+	fields, err := p.fields(line)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	if !p.seenMagic {
+		if len(fields) != 3 || fields[0]+" "+fields[1] != textMagic {
+			return &FormatError{Err: BadMagicErr, What: "header", Detail: fmt.Sprintf("first line %q", line)}
+		}
+		if version, err := strconv.ParseUint(fields[2], 10, 16); err != nil || uint16(version) != DULF_VERSION {
+			return &FormatError{Err: VersionErr, What: "header",
+				Detail: fmt.Sprintf("version %s, expected %d", fields[2], DULF_VERSION)}
+		}
+		p.seenMagic = true
+		return nil
+	}
+
+	obj := p.obj
+	keyword, args := fields[0], fields[1:]
+	if p.inData && isHexWord(keyword) {
+		section := p.sections[len(p.sections)-1]
+		for _, field := range fields {
+			if !isHexWord(field) {
+				return p.errorf("bad data word %q", field)
+			}
+			word, _ := strconv.ParseUint(field, 16, 16)
+			section.Data = append(section.Data, dubcc.MachineWord(word))
+		}
+		return nil
+	}
+	p.inData = keyword == "section"
+
+	switch keyword {
+	case "kind":
+		return p.parseValue(args, parseEnum(kindNames, 16, func(v uint64) { obj.Header.Kind = ObjectKind(v) }))
+	case "flags":
+		return p.parseValue(args, parseUint(16, func(v uint64) { obj.Header.Flags = uint16(v) }))
+	case "entry":
+		return p.parseValue(args, parseUint(64, func(v uint64) { obj.Header.Entry = v }))
+	case "string", "tail":
+		if len(args) != 1 || args[0][0] != '"' {
+			return p.errorf("%s takes one quoted string", keyword)
+		}
+		str, err := strconv.Unquote(args[0])
+		if err != nil {
+			return p.errorf("bad string %s: %v", args[0], err)
+		}
+		obj.StringTable = append(obj.StringTable, str...)
+		if keyword == "string" {
+			obj.StringTable = append(obj.StringTable, 0)
+		}
+		return nil
+	case "section":
+		return p.parseSection(args)
+	case "symbol":
+		return p.parseSymbol(args)
+	case "reloc":
+		return p.parseReloc(args)
+	default:
+		return p.errorf("unknown record %q", keyword)
+	}
+}
+
+func isHexWord(field string) bool {
+	_, err := strconv.ParseUint(field, 16, 16)
+	return len(field) == 4 && err == nil
+}
+
+// Reads "key value" pairs with the matching parsers. Every key must be given.
+func (p *textParser) parsePairs(args []string, keys map[string]func(string) error) error {
+	seen := make(map[string]bool)
+	for len(args) > 0 {
+		key := args[0]
+		set, known := keys[key]
+		if !known || seen[key] {
+			return p.errorf("unexpected %q", key)
+		}
+		if len(args) < 2 {
+			return p.errorf("%s needs a value", key)
+		}
+		if err := set(args[1]); err != nil {
+			return p.errorf("bad %s %q: %v", key, args[1], err)
+		}
+		seen[key] = true
+		args = args[2:]
+	}
+	for key := range keys {
+		if !seen[key] {
+			return p.errorf("missing %s", key)
+		}
+	}
+	return nil
+}
+
+func (p *textParser) parseValue(args []string, set func(string) error) error {
+	if len(args) != 1 {
+		return p.errorf("expected one value, got %d", len(args))
+	}
+	if err := set(args[0]); err != nil {
+		return p.errorf("bad value %q: %v", args[0], err)
+	}
+	return nil
+}
+
+func parseUint(bits int, set func(uint64)) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseUint(s, 0, bits)
+		if err == nil {
+			set(v)
+		}
+		return err
+	}
+}
+
+// One of names, or a number for values without one
+func parseEnum(names []string, bits int, set func(uint64)) func(string) error {
+	return func(s string) error {
+		if idx := slices.Index(names, s); idx >= 0 && s != "" {
+			set(uint64(idx))
+			return nil
+		}
+		return parseUint(bits, set)(s)
+	}
+}
+
+// A quoted name or @offset; quoted names are resolved at the end
+func (p *textParser) parseName(args []string, offset *uint32) ([]string, error) {
+	if len(args) == 0 {
+		return nil, p.errorf("missing name")
+	}
+	switch field := args[0]; {
+	case field[0] == '"':
+		name, err := strconv.Unquote(field)
+		if err != nil {
+			return nil, p.errorf("bad name %s: %v", field, err)
+		}
+		p.names = append(p.names, textNameRef{name, offset, p.pos()})
+	case field[0] == '@':
+		v, err := strconv.ParseUint(field[1:], 10, 32)
+		if err != nil {
+			return nil, p.errorf("bad name offset %s", field)
+		}
+		*offset = uint32(v)
+	default:
+		return nil, p.errorf("name %s must be quoted", field)
+	}
+	return args[1:], nil
+}
+
+func (p *textParser) parseSection(args []string) error {
+	section := &Section{}
+	header := &section.Header
+	args, err := p.parseName(args, &header.NameOffset)
+	if err != nil {
+		return err
+	}
+	p.sections = append(p.sections, section)
+	p.sectionLines = append(p.sectionLines, p.pos())
+	return p.parsePairs(args, map[string]func(string) error{
+		"type":    parseEnum(sectionTypeNames, 32, func(v uint64) { header.Type = DulfSection(v) }),
+		"flags":   parseUint(32, func(v uint64) { header.Flags = uint32(v) }),
+		"address": parseUint(64, func(v uint64) { header.Address = v }),
+		"words":   parseUint(31, func(v uint64) { header.Size = uint32(v * 2) }),
+		"link":    parseUint(32, func(v uint64) { header.Link = uint32(v) }),
+		"info":    parseUint(32, func(v uint64) { header.Info = uint32(v) }),
+		"align":   parseUint(32, func(v uint64) { header.Alignment = uint32(v) }),
+	})
+}
+
+func (p *textParser) parseSymbol(args []string) error {
+	symbol := &Symbol{}
+	args, err := p.parseName(args, &symbol.NameOffset)
+	if err != nil {
+		return err
+	}
+	var binding, symType uint64
+	err = p.parsePairs(args, map[string]func(string) error{
+		"section": func(s string) error {
+			switch s {
+			case "undef":
+				symbol.Section = SHN_UNDEF
+			case "abs":
+				symbol.Section = SHN_ABS
+			default:
+				return parseUint(16, func(v uint64) { symbol.Section = uint16(v) })(s)
+			}
+			return nil
+		},
+		"value": parseUint(64, func(v uint64) { symbol.Value = v }),
+		"size":  parseUint(32, func(v uint64) { symbol.Size = uint32(v) }),
+		"bind":  parseEnum(bindingNames, 4, func(v uint64) { binding = v }),
+		"type":  parseEnum(symbolTypeNames, 4, func(v uint64) { symType = v }),
+		"other": parseUint(8, func(v uint64) { symbol.Other = uint8(v) }),
+	})
+	if err != nil {
+		return err
+	}
+	symbol.SetInfo(SymbolBinding(binding), SymbolType(symType))
+	p.symbols = append(p.symbols, symbol)
+	p.symbolLines = append(p.symbolLines, p.pos())
+	return nil
+}
+func (p *textParser) parseReloc(args []string) error {
+	reloc := Relocation{}
+	var symbol, relocType uint64
+	err := p.parsePairs(args, map[string]func(string) error{
+		"section": parseUint(16, func(v uint64) { reloc.Section = uint16(v) }),
+		"offset":  parseUint(64, func(v uint64) { reloc.Offset = v }),
+		"type":    parseEnum(relocTypeNames, 8, func(v uint64) { relocType = v }),
+		"symbol":  parseUint(24, func(v uint64) { symbol = v }),
+		"addend": func(s string) error {
+			v, err := strconv.ParseInt(s, 0, 64)
+			reloc.Addend = v
+			return err
+		},
+	})
+	if err != nil {
+		return err
+	}
+	reloc.SetInfo(uint32(symbol), RelocationType(relocType))
+	p.obj.Relocations = append(p.obj.Relocations, reloc)
+	p.relocLines = append(p.relocLines, p.pos())
+	return nil
+}
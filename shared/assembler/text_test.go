@@ -0,0 +1,100 @@
+package assembler
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+func encodeText(t testing.TB, obj *ObjectFile) string {
+	var buf bytes.Buffer
+	if err := obj.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	return buf.String()
+}
+
+// binary -> text -> binary gives the same bytes, and Read takes either
+func checkTextRoundTrip(t *testing.T, obj *ObjectFile) {
+	t.Helper()
+	data := encode(t, obj)
+	text := encodeText(t, obj)
+	got, err := Read(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("Read of\n%s: %v", text, err)
+	}
+	want, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	sameObject(t, want, got)
+	if !bytes.Equal(data, encode(t, got)) {
+		t.Fatalf("binary written from the text object differs\n%s", text)
+	}
+	if again := encodeText(t, got); again != text {
+		t.Fatalf("text changed after a round trip:\n%s\nthen\n%s", text, again)
+	}
+}
+
+func TestTextRoundTripSample(t *testing.T) {
+	checkTextRoundTrip(t, assembleSample(t))
+}
+
+func TestTextRoundTripRandom(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	for range 500 {
+		obj := randomObject(rng)
+		// names that aren't the first copy of their string, and odd bytes
+		if rng.IntN(4) == 0 && len(obj.Symbols) > 0 {
+			obj.StringTable = append(obj.StringTable, "main\x00\xff;\"x\x00"...)
+			obj.Symbols[0].NameOffset = uint32(len(obj.StringTable)) - 9
+		}
+		if rng.IntN(8) == 0 {
+			obj.StringTable = append(obj.StringTable, "no end"...)
+		}
+		checkTextRoundTrip(t, obj)
+	}
+}
+
+func TestReadTextErrors(t *testing.T) {
+	valid := encodeText(t, assembleSample(t))
+	replace := func(old, new string) string {
+		if !strings.Contains(valid, old) {
+			t.Fatalf("sample has no %q:\n%s", old, valid)
+		}
+		return strings.Replace(valid, old, new, 1)
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want error
+	}{
+		{"version", replace("DULF text 3", "DULF text 2"), VersionErr},
+		{"unknown record", valid + "bogus 1\n", TextSyntaxErr},
+		{"missing field", replace(" other 0\n", "\n"), TextSyntaxErr},
+		{"bad number", replace("entry 0x", "entry 0xg"), TextSyntaxErr},
+		{"data outside a section", replace("kind rel", "kind rel\n0203"), TextSyntaxErr},
+		{"short data", replace("\t0000 ", "\t"), TextSyntaxErr},
+		{"unknown name", replace(`symbol "main"`, `symbol "nothere"`), OffsetRangeErr},
+		{"symbol section", replace("section 2 value", "section 9 value"), SectionIndexErr},
+		{"duplicate field", replace("addend 0", "addend 0 symbol 1"), TextSyntaxErr},
+		{"relocation symbol", replace("symbol 4 addend", "symbol 9 addend"), SymbolIndexErr},
+		{"relocation section", replace("reloc section 0", "reloc section 7"), SectionIndexErr},
+		{"relocation offset", replace("offset 0x0005", "offset 0x0050"), OffsetRangeErr},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(test.text))
+			if !errors.Is(err, test.want) {
+				t.Fatalf("want %v, got %v", test.want, err)
+			}
+			var formatErr *FormatError
+			if !errors.As(err, &formatErr) {
+				t.Fatalf("%v is not a *FormatError", err)
+			}
+		})
+	}
+}
@@ -32,13 +32,14 @@ type InLine struct {
 
 // Função que recebe a linha em assembly e separa em rótulo, operações/instruções.
 func ParseAsmLine(rawLine string) (line InLine, err error) {
-	label, code, labeled := strings.Cut(rawLine, ":")
+	// ignore comments (before looking for a label, they may contain ':')
+	code, _, _ := strings.Cut(rawLine, ";")
+	label, code, labeled := strings.Cut(code, ":")
 	if !labeled {
 		code = label
 		label = ""
 	}
-	// ignore comments
-	code, _, _ = strings.Cut(code, ";")
+	label = strings.TrimSpace(label)
 	fields := strings.Fields(code)
 	op := ""
 	if len(fields) > 0 { op = fields[0] }
@@ -57,10 +58,12 @@ type Sim struct {
 	Registers []MachineWord
 	Isa       ISA
 	State     SimState
+	Fault     *Fault
 	SaveTemps	bool
 	TempDir   string
 	InWords   []MachineWord
 	OutWords  []MachineWord
+	Steps     uint64 // instructions executed so far
 }
 
 type SimState = byte
@@ -71,49 +74,64 @@ const (
 	SimStateLoop
 	SimStateIOBlocked
 	SimStatePause
+	SimStateFault
 )
 
 type SimMem struct {
 	Work []MachineWord
 }
 
+// Returns pointers to the operands of opword, or nil if resolving them
+// raised a fault.
 func (s *Sim) ResolveAddressMode(opword MachineWord, args []MachineWord) []*MachineWord {
 	inst, found := s.InstructionFromWord(opword)
 	if !found {
-		panic("bad instruction")
+		s.Raise(FaultIllegalOpcode, opword)
+		return nil
 	}
 	if inst.NumArgs != len(args) {
-		panic("argument count mismatch")
-	}
-
-	immediateTests := []func() bool{
-		func() bool { return (opword&OpImmediateFlag) != 0 && (inst.Flags&InstImmediateA) != 0 },
-		func() bool { return (opword&OpImmediateFlag) != 0 && (inst.Flags&InstImmediateB) != 0 },
-	}
-	indirectTests := []func() bool{
-		func() bool { return (opword & OpIndirectAFlag) != 0 },
-		func() bool { return (opword & OpIndirectBFlag) != 0 },
-	}
-	registerTests := []func() bool{
-		func() bool { return (opword & OpRegAFlag) != 0 },
-		func() bool { return (opword & OpRegBFlag) != 0 },
+		s.Raise(FaultIllegalOperand, MachineWord(len(args)))
+		return nil
 	}
 
 	out := make([]*MachineWord, 2)
 	for idx, arg := range args {
-		isIm := immediateTests[idx]()
-		isIn := indirectTests[idx]()
-		isReg := registerTests[idx]()
+		mode := inst.OperandMode(opword, idx)
+		if !inst.Allows(idx, mode) {
+			s.Raise(FaultIllegalOperand, arg)
+			return nil
+		}
 
-		if isReg {
+		switch mode {
+		case ModeRegister:
+			if int(arg) >= len(s.Registers) {
+				s.Raise(FaultIllegalOperand, arg)
+				return nil
+			}
 			out[idx] = &s.Registers[arg]
-		} else if isIm {
+		case ModeImmediate:
 			box := new(MachineWord)
 			*box = arg
 			out[idx] = box
-		} else if isIn {
-			out[idx] = &s.Mem.Work[s.Mem.Work[arg]]
-		} else { // only direct remaining
+		case ModeRelative:
+			// PC already points past the operands
+			at := s.GetRegister(RegPC) - MachineWord(len(args)-idx)
+			box := new(MachineWord)
+			*box = at + arg
+			out[idx] = box
+		case ModeIndirect:
+			ptr := s.memAt(arg)
+			if ptr == nil {
+				return nil
+			}
+			if (inst.Flags & InstDirectIsImmediate) != 0 {
+				// branch targets are one level shallower:
+				// "br X,I" jumps to the address stored at X
+				out[idx] = ptr
+			} else {
+				out[idx] = s.memAt(*ptr)
+			}
+		default: // only direct remaining
 			if (inst.Flags & InstDirectIsImmediate) != 0 {
 				// botch for the uuuh branch instructions?
 				// where Direct is a goddamn alias for Im
@@ -121,9 +139,12 @@ func (s *Sim) ResolveAddressMode(opword MachineWord, args []MachineWord) []*Mach
 				*box = arg
 				out[idx] = box
 			} else {
-				out[idx] = &s.Mem.Work[arg] // direct
+				out[idx] = s.memAt(arg) // direct
 			}
 		}
+		if out[idx] == nil {
+			return nil
+		}
 	}
 	return out
 }
@@ -0,0 +1,118 @@
+package dubcc
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Severity byte
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	default:
+		return fmt.Sprintf("Severity(%d)", byte(s))
+	}
+}
+
+// Mensagem do macroprocessador, montador ou ligador.
+// Line and Col are 1 based, 0 means unknown.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Col      int
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) Error() string {
+	pos := d.File
+	if pos == "" {
+		pos = "<input>"
+	}
+	if d.Line > 0 {
+		pos += fmt.Sprintf(":%d", d.Line)
+		if d.Col > 0 {
+			pos += fmt.Sprintf(":%d", d.Col)
+		}
+	}
+	return fmt.Sprintf("%s: %v: %s", pos, d.Severity, d.Message)
+}
+
+// Diagnostics collects every problem of a stage instead of stopping at
+// the first one. It is an error itself so it can be returned as one.
+type Diagnostics []Diagnostic
+
+func (ds *Diagnostics) Add(severity Severity, file string, line, col int, format string, args ...any) {
+	*ds = append(*ds, Diagnostic{
+		File:     file,
+		Line:     line,
+		Col:      col,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (ds *Diagnostics) Errorf(file string, line, col int, format string, args ...any) {
+	ds.Add(SeverityError, file, line, col, format, args...)
+}
+
+func (ds *Diagnostics) Warnf(file string, line, col int, format string, args ...any) {
+	ds.Add(SeverityWarning, file, line, col, format, args...)
+}
+
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns ds as an error if any of them is an error, nil otherwise
+func (ds Diagnostics) Err() error {
+	if ds.HasErrors() {
+		return ds
+	}
+	return nil
+}
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i, d := range ds {
+		lines[i] = d.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Column (1 based) of the first occurrence of needle in line, 0 if absent
+func ColumnOf(line string, needle string) int {
+	if needle == "" {
+		return 0
+	}
+	idx := strings.Index(line, needle)
+	if idx < 0 {
+		return 0
+	}
+	return idx + 1
+}
+
+// Linha já expandida pelo macroprocessador, com a sua origem no fonte
+type SourceLine struct {
+	File  string
+	Line  int    // line in File that produced Text
+	Text  string // line fed to the assembler
+	Macro string // macro this line was expanded from, "" if none
+}
@@ -0,0 +1,215 @@
+package dubcc
+
+import (
+	"fmt"
+	"strings"
+)
+
+// One operand of a disassembled instruction
+type DisasmOperand struct {
+	Address MachineAddress // of the operand word
+	Mode    AddrMode
+	Value   MachineWord    // the word as stored
+	Target  MachineAddress // direct, indirect and relative: what it points to
+	Text    string         // in source syntax: "ACC", "5", "loop", "tbl+1,I", "loop,R"
+}
+
+// An instruction, or a data word that isn't one (Op is "const")
+type DisasmLine struct {
+	Address  MachineAddress
+	Words    []MachineWord
+	Labels   []string // names defined at Address
+	Op       string
+	Operands []DisasmOperand
+}
+
+// "load tbl+1" or "const 0x0040", without the labels
+func (line DisasmLine) String() string {
+	fields := []string{line.Op}
+	for _, operand := range line.Operands {
+		fields = append(fields, operand.Text)
+	}
+	return strings.Join(fields, " ")
+}
+
+// Assembler source for the lines: labels on lines of their own, then the
+// instruction
+func DisasmSource(lines []DisasmLine) string {
+	var b strings.Builder
+	for _, line := range lines {
+		for _, label := range line.Labels {
+			fmt.Fprintf(&b, "%s:\n", label)
+		}
+		fmt.Fprintf(&b, "\t%s\n", line)
+	}
+	return b.String()
+}
+
+// A word that isn't decoded, written as a const
+func DataLine(addr MachineAddress, word MachineWord) DisasmLine {
+	return DisasmLine{
+		Address: addr,
+		Words:   []MachineWord{word},
+		Op:      "const",
+		Operands: []DisasmOperand{{
+			Address: addr,
+			Mode:    ModeImmediate,
+			Value:   word,
+			Text:    fmt.Sprintf("0x%04x", word),
+		}},
+	}
+}
+
+// Turns words loaded at base back into assembly, one line per instruction.
+// Words that aren't an instruction the assembler would write (unknown
+// opcode, flags it wouldn't set, a bad register, missing operands or a
+// symbol in the middle) come out as const.
+//
+// Operands pointing to memory use the name in symbols for their target.
+// Targets without one get a label (L and the address in hex) when they are
+// inside words, and are written as an offset from the first line otherwise,
+// so with base 0 the source assembles back to the same words. Symbols that
+// don't fall on the start of a line are left out.
+func Disassemble(words []MachineWord, base MachineAddress, symbols map[MachineAddress]string) []DisasmLine {
+	isa := GetDefaultISA()
+	byRepr := make(map[MachineWord]Instruction)
+	for _, inst := range isa.Instructions {
+		byRepr[inst.Repr] = inst
+	}
+	regNames := make(map[MachineWord]string)
+	for name, reg := range isa.Registers {
+		regNames[MachineWord(reg.Address)] = name
+	}
+
+	var lines []DisasmLine
+	for pos := 0; pos < len(words); {
+		addr := base + MachineAddress(pos)
+		line, ok := decode(words[pos:], addr, byRepr, regNames)
+		for idx := 1; ok && idx < len(line.Words); idx++ {
+			_, named := symbols[addr+MachineAddress(idx)]
+			ok = !named
+		}
+		if !ok {
+			line = DataLine(addr, words[pos])
+		}
+		lines = append(lines, line)
+		pos += len(line.Words)
+	}
+
+	// line that starts at, or contains, each address
+	lineAt := make(map[MachineAddress]int)
+	for idx, line := range lines {
+		for offset := range line.Words {
+			lineAt[line.Address+MachineAddress(offset)] = idx
+		}
+	}
+	used := make(map[string]bool)
+	for _, name := range symbols {
+		used[name] = true
+	}
+	labelOf := func(idx int) string {
+		line := &lines[idx]
+		if len(line.Labels) == 0 {
+			name, found := symbols[line.Address]
+			if !found {
+				name = fmt.Sprintf("L%04x", line.Address)
+				for n := 1; used[name]; n++ {
+					name = fmt.Sprintf("L%04x_%d", line.Address, n)
+				}
+				used[name] = true
+			}
+			line.Labels = []string{name}
+		}
+		return line.Labels[0]
+	}
+	nameOf := func(target MachineAddress) string {
+		idx, inside := lineAt[target]
+		if !inside {
+			if name, found := symbols[target]; found {
+				return name
+			}
+			idx = 0
+		}
+		return withAddend(labelOf(idx), int64(target)-int64(lines[idx].Address))
+	}
+
+	for idx := range lines {
+		if _, found := symbols[lines[idx].Address]; found {
+			labelOf(idx)
+		}
+	}
+	for idx := range lines {
+		if lines[idx].Op == "const" {
+			continue
+		}
+		for o := range lines[idx].Operands {
+			operand := &lines[idx].Operands[o]
+			switch operand.Mode {
+			case ModeDirect:
+				operand.Text = nameOf(operand.Target)
+			case ModeIndirect:
+				operand.Text = nameOf(operand.Target) + ",I"
+			case ModeRelative:
+				operand.Text = nameOf(operand.Target) + ",R"
+			}
+		}
+	}
+	return lines
+}
+
+// Decodes the instruction at the start of words, if it's one the
+// assembler would write the same way
+func decode(
+	words []MachineWord,
+	addr MachineAddress,
+	byRepr map[MachineWord]Instruction,
+	regNames map[MachineWord]string,
+) (line DisasmLine, ok bool) {
+	opword := words[0]
+	inst, found := byRepr[opword&0x1f]
+	if !found || len(words) < 1+inst.NumArgs {
+		return line, false
+	}
+	line = DisasmLine{Address: addr, Words: words[:1+inst.NumArgs], Op: inst.Name}
+
+	regFlags := []MachineWord{OpRegAFlag, OpRegBFlag}
+	indirectFlags := []MachineWord{OpIndirectAFlag, OpIndirectBFlag}
+	canonical := inst.Repr
+	for idx, value := range words[1 : 1+inst.NumArgs] {
+		operand := DisasmOperand{
+			Address: addr + 1 + MachineAddress(idx),
+			Mode:    inst.OperandMode(opword, idx),
+			Value:   value,
+		}
+		if !inst.Allows(idx, operand.Mode) {
+			return line, false
+		}
+		switch operand.Mode {
+		case ModeRegister:
+			if operand.Text, found = regNames[value]; !found {
+				return line, false
+			}
+			canonical |= regFlags[idx]
+		case ModeImmediate:
+			operand.Text = fmt.Sprint(value)
+			canonical |= OpImmediateFlag
+		case ModeIndirect:
+			operand.Target = MachineAddress(value)
+			canonical |= indirectFlags[idx]
+		case ModeRelative:
+			operand.Target = MachineAddress(MachineWord(operand.Address) + value)
+			canonical |= OpRelativeFlag
+		default:
+			operand.Target = MachineAddress(value)
+		}
+		line.Operands = append(line.Operands, operand)
+	}
+	return line, opword == canonical
+}
+
+func withAddend(name string, addend int64) string {
+	if addend == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s%+d", name, addend)
+}
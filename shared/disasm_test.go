@@ -0,0 +1,122 @@
+package dubcc_test
+
+import (
+	"dubcc"
+	"dubcc/macroprocessor"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Assembles source like dubasm --raw does, returning the memory image and
+// the address of each symbol
+func assemble(t *testing.T, name, source string) ([]dubcc.MachineWord, map[string]dubcc.MachineAddress) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	info, diags, err := macroprocessor.AssembleSource(name, source)
+	if err != nil {
+		t.Fatalf("assembling %s: %v", name, diags)
+	}
+	symbols := make(map[string]dubcc.MachineAddress)
+	for _, symbol := range info.GetSymbols() {
+		symbols[symbol], _ = info.SymbolAddress(symbol)
+	}
+	return info.GetOutput(), symbols
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	samples, err := filepath.Glob("../samples/*.asm")
+	if err != nil || len(samples) == 0 {
+		t.Fatalf("no samples: %v", err)
+	}
+	for _, sample := range samples {
+		t.Run(filepath.Base(sample), func(t *testing.T) {
+			source, err := os.ReadFile(sample)
+			if err != nil {
+				t.Fatal(err)
+			}
+			words, symbols := assemble(t, sample, string(source))
+
+			// the first name in alphabetical order when there are several
+			names := make(map[dubcc.MachineAddress]string)
+			for name, addr := range symbols {
+				if other, found := names[addr]; !found || name < other {
+					names[addr] = name
+				}
+			}
+			for _, labels := range []map[dubcc.MachineAddress]string{nil, names} {
+				text := dubcc.DisasmSource(dubcc.Disassemble(words, 0, labels))
+				again, _ := assemble(t, "disassembled", text)
+				if !slices.Equal(again, words) {
+					t.Fatalf("round trip changed the words\n got %04x\nwant %04x\nsource:\n%s", again, words, text)
+				}
+			}
+		})
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	words := []dubcc.MachineWord{
+		0x0203, 0x0003, // load 3
+		0x0007, 0x0001, // store into the operand of the load
+		0x000d | dubcc.OpRegAFlag | dubcc.OpImmediateFlag, 0x0002, 0x0007, // copy ACC 7
+		0x000f | dubcc.OpRelativeFlag, 0xfff8, // call back to the start
+		0x0003 | dubcc.OpIndirectAFlag, 0x000e, // load through a data word
+		0x000b,         // stop
+		0x0003 | 0x100, // register flag for an operand load doesn't have
+		0x001f,         // unknown opcode
+		0x0002 | 0x040, // same for indirect, on add
+		0x0088, 0x0009, // write, but 9 isn't a register
+		0x0003, // load without its operand
+	}
+	want := []string{
+		"load 3",
+		"store L0000+1",
+		"copy ACC 7",
+		"call L0000,R",
+		"load L000e,I",
+		"stop",
+		"const 0x0103",
+		"const 0x001f",
+		"const 0x0042",
+		"const 0x0088",
+		"const 0x0009",
+		"const 0x0003",
+	}
+	lines := dubcc.Disassemble(words, 0, nil)
+	var got []string
+	for _, line := range lines {
+		got = append(got, line.String())
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+	if !slices.Equal(lines[0].Labels, []string{"L0000"}) || !slices.Equal(lines[8].Labels, []string{"L000e"}) {
+		t.Errorf("labels %v and %v, want L0000 and L000e", lines[0].Labels, lines[8].Labels)
+	}
+}
+
+func TestDisassembleSymbols(t *testing.T) {
+	words := []dubcc.MachineWord{
+		0x0003, 0x0100, // load from outside of words, named
+		0x0007, 0x0200, // store outside of words, not named
+		0x0000 | dubcc.OpRelativeFlag, 0x0000, // br with a symbol on its operand
+	}
+	symbols := map[dubcc.MachineAddress]string{0x40: "start", 0x100: "value", 0x45: "middle"}
+	lines := dubcc.Disassemble(words, 0x40, symbols)
+
+	want := []string{"load value", "store start+448", "const 0x0400", "const 0x0000"}
+	var got []string
+	for _, line := range lines {
+		got = append(got, line.String())
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+	if !slices.Equal(lines[0].Labels, []string{"start"}) || !slices.Equal(lines[3].Labels, []string{"middle"}) {
+		t.Errorf("labels %v and %v, want start and middle", lines[0].Labels, lines[3].Labels)
+	}
+}
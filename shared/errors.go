@@ -4,4 +4,4 @@ import (
 	"errors"
 )
 var EmptyLineErr = errors.New("empty line")
-
+var StepLimitErr = errors.New("step limit reached")
@@ -0,0 +1,98 @@
+package dubcc
+
+import (
+	"fmt"
+)
+
+type FaultKind byte
+
+const (
+	FaultIllegalOpcode  FaultKind = iota // opword doesn't decode to an instruction
+	FaultIllegalOperand                  // bad register index or argument count
+	FaultDivideByZero
+	FaultMemoryBounds // address outside of Mem.Work
+	FaultStackOverflow
+	FaultStackUnderflow
+)
+
+func (k FaultKind) String() string {
+	switch k {
+	case FaultIllegalOpcode:
+		return "illegal opcode"
+	case FaultIllegalOperand:
+		return "illegal operand"
+	case FaultDivideByZero:
+		return "divide by zero"
+	case FaultMemoryBounds:
+		return "memory bounds violation"
+	case FaultStackOverflow:
+		return "stack overflow"
+	case FaultStackUnderflow:
+		return "stack underflow"
+	default:
+		return fmt.Sprintf("FaultKind(%d)", byte(k))
+	}
+}
+
+// Diagnóstico de uma instrução que parou a máquina
+type Fault struct {
+	Kind FaultKind
+	PC   MachineWord // address of the faulting instruction
+	Word MachineWord // faulting opword
+	Addr MachineWord // offending address/operand, when it applies
+}
+
+func (f *Fault) Error() string {
+	switch f.Kind {
+	case FaultMemoryBounds, FaultStackOverflow, FaultStackUnderflow, FaultIllegalOperand:
+		return fmt.Sprintf("%v at pc 0x%04x (opword 0x%04x, operand 0x%04x)", f.Kind, f.PC, f.Word, f.Addr)
+	default:
+		return fmt.Sprintf("%v at pc 0x%04x (opword 0x%04x)", f.Kind, f.PC, f.Word)
+	}
+}
+
+// Stops the machine with a fault. PC and Word are filled in by Step.
+func (s *Sim) Raise(kind FaultKind, addr MachineWord) {
+	s.Fault = &Fault{Kind: kind, Addr: addr}
+	s.State = SimStateFault
+}
+
+func (s *Sim) Faulted() bool {
+	return s.State == SimStateFault
+}
+
+// Address of the bottom of the stack, SP grows upwards from it.
+func (s *Sim) StackBase() MachineWord {
+	return MachineWord(len(s.Mem.Work) / 2)
+}
+
+func (s *Sim) memAt(addr MachineWord) *MachineWord {
+	if int(addr) >= len(s.Mem.Work) {
+		s.Raise(FaultMemoryBounds, addr)
+		return nil
+	}
+	return &s.Mem.Work[addr]
+}
+
+func (s *Sim) pushWord(w MachineWord) {
+	sp := s.GetRegister(RegSP)
+	if int(sp) >= len(s.Mem.Work) {
+		s.Raise(FaultStackOverflow, sp)
+		return
+	}
+	s.Mem.Work[sp] = w
+	s.SetRegister(RegSP, sp+1)
+}
+
+func (s *Sim) popWord() (MachineWord, bool) {
+	sp := s.GetRegister(RegSP)
+	if sp <= s.StackBase() || int(sp) > len(s.Mem.Work) {
+		s.Raise(FaultStackUnderflow, sp)
+		return 0, false
+	}
+	sp--
+	s.SetRegister(RegSP, sp)
+	w := s.Mem.Work[sp]
+	s.Mem.Work[sp] = 0
+	return w, true
+}
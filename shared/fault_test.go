@@ -0,0 +1,76 @@
+package dubcc_test
+
+import (
+	"context"
+	"dubcc"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		at    dubcc.MachineAddress // where the words go, and the entry point
+		words []dubcc.MachineWord
+		sp    dubcc.MachineWord // 0 keeps the startup SP (0x80)
+		want  dubcc.Fault
+	}{
+		{"illegal opcode", 0, []dubcc.MachineWord{0x0203, 0x0005, 0x001f},
+			0, dubcc.Fault{Kind: dubcc.FaultIllegalOpcode, PC: 2, Word: 0x001f, Addr: 0x001f}},
+		{"bad register", 0, []dubcc.MachineWord{0x0088, 0x0009}, // write R9
+			0, dubcc.Fault{Kind: dubcc.FaultIllegalOperand, PC: 0, Word: 0x0088, Addr: 9}},
+		{"disallowed mode", 0, []dubcc.MachineWord{0x0080, 0x0006}, // br R0
+			0, dubcc.Fault{Kind: dubcc.FaultIllegalOperand, PC: 0, Word: 0x0080, Addr: 6}},
+		{"divide by zero", 0, []dubcc.MachineWord{0x0203, 0x0005, 0x020a, 0x0000},
+			0, dubcc.Fault{Kind: dubcc.FaultDivideByZero, PC: 2, Word: 0x020a}},
+		{"direct operand", 0, []dubcc.MachineWord{0x0003, 0x0200}, // load 0x200
+			0, dubcc.Fault{Kind: dubcc.FaultMemoryBounds, PC: 0, Word: 0x0003, Addr: 0x200}},
+		{"indirect operand", 0, []dubcc.MachineWord{0x0023, 0x0002, 0x0300}, // load 2,I
+			0, dubcc.Fault{Kind: dubcc.FaultMemoryBounds, PC: 0, Word: 0x0023, Addr: 0x300}},
+		{"operand past the end", 0xff, []dubcc.MachineWord{0x0003},
+			0, dubcc.Fault{Kind: dubcc.FaultMemoryBounds, PC: 0xff, Word: 0x0003, Addr: 0x100}},
+		{"branch out of memory", 0, []dubcc.MachineWord{0x0200, 0x0200}, // br 0x200
+			0, dubcc.Fault{Kind: dubcc.FaultMemoryBounds, PC: 0x200, Addr: 0x200}},
+		{"push overflow", 0, []dubcc.MachineWord{0x0211, 0x0001}, // push 1
+			0x100, dubcc.Fault{Kind: dubcc.FaultStackOverflow, PC: 0, Word: 0x0211, Addr: 0x100}},
+		{"call overflow", 0, []dubcc.MachineWord{0x020f, 0x0010}, // call 16
+			0x100, dubcc.Fault{Kind: dubcc.FaultStackOverflow, PC: 0, Word: 0x020f, Addr: 0x100}},
+		{"ret underflow", 0, []dubcc.MachineWord{0x0010},
+			0, dubcc.Fault{Kind: dubcc.FaultStackUnderflow, PC: 0, Word: 0x0010, Addr: 0x80}},
+		{"pop underflow", 0, []dubcc.MachineWord{0x0092, 0x0000}, // pop ACC
+			0, dubcc.Fault{Kind: dubcc.FaultStackUnderflow, PC: 0, Word: 0x0092, Addr: 0x80}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sim := dubcc.MakeSim(0x100)
+			img := dubcc.Image{Segments: []dubcc.Segment{{Name: "test", Address: test.at, Data: test.words}}, Entry: test.at}
+			if err := sim.LoadImage(img); err != nil {
+				t.Fatal(err)
+			}
+			if test.sp != 0 {
+				sim.SetRegister(dubcc.RegSP, test.sp)
+			}
+			sim.State = dubcc.SimStateRun
+
+			result, err := sim.Run(context.Background(), 10)
+			var fault *dubcc.Fault
+			if result.Kind != dubcc.StepFault || !errors.As(err, &fault) {
+				t.Fatalf("got %v, %v", result.Kind, err)
+			}
+			if *fault != test.want || sim.Fault != fault {
+				t.Fatalf("got %+v, want %+v", *fault, test.want)
+			}
+			if !strings.Contains(fault.Error(), test.want.Kind.String()) {
+				t.Errorf("%q doesn't say %v", fault.Error(), test.want.Kind)
+			}
+			// PC stays on the faulting instruction, and the machine stays stopped
+			if pc := sim.GetRegister(dubcc.RegPC); pc != test.want.PC {
+				t.Errorf("PC = 0x%04x, want 0x%04x", pc, test.want.PC)
+			}
+			if again := sim.Step(); again.Kind != dubcc.StepFault || again.Err != fault || !sim.Faulted() {
+				t.Errorf("stepping again: %v, %v", again.Kind, again.Err)
+			}
+		})
+	}
+}
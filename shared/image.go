@@ -0,0 +1,74 @@
+package dubcc
+
+import (
+	"fmt"
+)
+
+// Bloco contíguo de memória a ser carregado
+type Segment struct {
+	Name    string
+	Address MachineAddress
+	Data    []MachineWord
+}
+
+// What a loader needs to put a program in memory, independent of the file
+// format it came from.
+type Image struct {
+	Segments    []Segment
+	Entry       MachineAddress
+	Relocatable bool    // can be loaded at any base address
+	Fixups      []Fixup // words holding addresses, for the relocating loader
+}
+
+// Palavra que guarda um endereço relativo ao início do programa
+type Fixup struct {
+	Segment int
+	Offset  MachineAddress // in words from the start of the segment
+}
+
+func (seg Segment) End() MachineAddress {
+	return seg.Address + MachineAddress(len(seg.Data))
+}
+
+// Copies every segment into memory and points PC at the entry point.
+// Segments that don't fit in memory or overlap each other are an error.
+func (s *Sim) LoadImage(img Image) error {
+	return s.LoadImageAt(img, 0)
+}
+
+// Relocating loader: moves every segment and the entry point up by base
+// and adds base to each fixup word, so the program runs from there.
+func (s *Sim) LoadImageAt(img Image, base MachineAddress) error {
+	if base != 0 && !img.Relocatable {
+		return fmt.Errorf("program was linked for fixed addresses, it can't be loaded at 0x%04x", base)
+	}
+	memSize := MachineAddress(len(s.Mem.Work))
+	for i, seg := range img.Segments {
+		if base+seg.End() > memSize {
+			return fmt.Errorf("segment %s [0x%04x, 0x%04x) doesn't fit in %d words of memory",
+				seg.Name, base+seg.Address, base+seg.End(), memSize)
+		}
+		for _, other := range img.Segments[:i] {
+			if seg.Address < other.End() && other.Address < seg.End() {
+				return fmt.Errorf("segment %s overlaps segment %s", seg.Name, other.Name)
+			}
+		}
+	}
+	if base+img.Entry >= memSize {
+		return fmt.Errorf("entry point 0x%04x outside of memory", base+img.Entry)
+	}
+	for _, fixup := range img.Fixups {
+		if fixup.Segment >= len(img.Segments) || fixup.Offset >= MachineAddress(len(img.Segments[fixup.Segment].Data)) {
+			return fmt.Errorf("fixup at %d of segment %d is outside of the program", fixup.Offset, fixup.Segment)
+		}
+	}
+
+	for _, seg := range img.Segments {
+		copy(s.Mem.Work[base+seg.Address:], seg.Data)
+	}
+	for _, fixup := range img.Fixups {
+		s.Mem.Work[base+img.Segments[fixup.Segment].Address+fixup.Offset] += MachineWord(base)
+	}
+	s.SetRegister(RegPC, MachineWord(base+img.Entry))
+	return nil
+}
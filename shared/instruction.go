@@ -1,22 +1,68 @@
 package dubcc
 
+import (
+	"slices"
+	"strings"
+)
+
 type Instruction struct {
 	Name    string
 	NumArgs int
 	Repr    MachineWord
 	Flags   InstructionFlag
+	Modes   [2]AddrMode // allowed addressing modes per operand
 }
 
 // static flags time
 type InstructionFlag byte
 
 const (
-	InstImmediateA = 1 << iota // Accepts Immediate values
-	InstImmediateB
-	InstDirectIsImmediate
+	InstDirectIsImmediate = 1 << iota // Direct operands are used as values (branches)
 	InstStack
 )
 
+// addressing modes an operand may take
+type AddrMode byte
+
+const (
+	ModeDirect AddrMode = 1 << iota
+	ModeIndirect
+	ModeImmediate
+	ModeRegister
+	ModeRelative // branch target is the operand's own address plus the operand
+
+	ModeMemory = ModeDirect | ModeIndirect
+	ModeAny    = ModeMemory | ModeImmediate | ModeRegister
+)
+
+func (m AddrMode) String() string {
+	names := []string{}
+	for mode, name := range map[AddrMode]string{
+		ModeDirect:    "direct",
+		ModeIndirect:  "indirect",
+		ModeImmediate: "immediate",
+		ModeRegister:  "register",
+		ModeRelative:  "relative",
+	} {
+		if m&mode != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// Whether operand idx (0 based) of inst accepts mode
+func (inst Instruction) Allows(idx int, mode AddrMode) bool {
+	if idx < 0 || idx >= inst.NumArgs {
+		return false
+	}
+	return inst.Modes[idx]&mode != 0
+}
+
 // runtime flags
 const (
 	OpIndirectAFlag = (1 << 5) << iota
@@ -24,8 +70,29 @@ const (
 	OpRegAFlag
 	OpRegBFlag
 	OpImmediateFlag
+	OpRelativeFlag
 )
 
+// Addressing mode operand idx (0 based) was encoded with in opword.
+// The immediate and relative flags are shared, so they only apply to
+// operands that allow them.
+func (inst Instruction) OperandMode(opword MachineWord, idx int) AddrMode {
+	regFlags := []MachineWord{OpRegAFlag, OpRegBFlag}
+	indirectFlags := []MachineWord{OpIndirectAFlag, OpIndirectBFlag}
+	switch {
+	case opword&regFlags[idx] != 0:
+		return ModeRegister
+	case opword&OpRelativeFlag != 0 && inst.Allows(idx, ModeRelative):
+		return ModeRelative
+	case opword&OpImmediateFlag != 0 && inst.Allows(idx, ModeImmediate):
+		return ModeImmediate
+	case opword&indirectFlags[idx] != 0:
+		return ModeIndirect
+	default:
+		return ModeDirect
+	}
+}
+
 func (sim *Sim) InstructionFromWord(
 	word MachineWord,
 ) (Instruction, bool) {
@@ -47,26 +114,27 @@ func IsIndirectB(op MachineWord) bool {
 }
 
 type inst = Instruction // shorthand for these defs
+type modes = [2]AddrMode
 func InstMap() map[string]Instruction {
 	return map[string]Instruction{
-		"add":    inst{Name: "add", NumArgs: 1, Repr: 2, Flags: InstImmediateA},
-		"br":     inst{Name: "br", NumArgs: 1, Repr: 0, Flags: InstDirectIsImmediate},
-		"brneg":  inst{Name: "brneg", NumArgs: 1, Repr: 5, Flags: InstDirectIsImmediate},
-		"brpos":  inst{Name: "brpos", NumArgs: 1, Repr: 1, Flags: InstDirectIsImmediate},
-		"brzero": inst{Name: "brzero", NumArgs: 1, Repr: 4, Flags: InstDirectIsImmediate},
-		"copy":   inst{Name: "copy", NumArgs: 2, Repr: 13, Flags: InstImmediateB},
-		"divide": inst{Name: "divide", NumArgs: 1, Repr: 10, Flags: InstImmediateA},
-		"load":   inst{Name: "load", NumArgs: 1, Repr: 3, Flags: InstImmediateA},
-		"mult":   inst{Name: "mult", NumArgs: 1, Repr: 14, Flags: InstImmediateA},
-		"read":   inst{Name: "read", NumArgs: 1, Repr: 12, Flags: 0},
+		"add":    inst{Name: "add", NumArgs: 1, Repr: 2, Modes: modes{ModeAny}},
+		"br":     inst{Name: "br", NumArgs: 1, Repr: 0, Flags: InstDirectIsImmediate, Modes: modes{ModeMemory | ModeImmediate | ModeRelative}},
+		"brneg":  inst{Name: "brneg", NumArgs: 1, Repr: 5, Flags: InstDirectIsImmediate, Modes: modes{ModeMemory | ModeImmediate | ModeRelative}},
+		"brpos":  inst{Name: "brpos", NumArgs: 1, Repr: 1, Flags: InstDirectIsImmediate, Modes: modes{ModeMemory | ModeImmediate | ModeRelative}},
+		"brzero": inst{Name: "brzero", NumArgs: 1, Repr: 4, Flags: InstDirectIsImmediate, Modes: modes{ModeMemory | ModeImmediate | ModeRelative}},
+		"copy":   inst{Name: "copy", NumArgs: 2, Repr: 13, Modes: modes{ModeMemory | ModeRegister, ModeAny}},
+		"divide": inst{Name: "divide", NumArgs: 1, Repr: 10, Modes: modes{ModeAny}},
+		"load":   inst{Name: "load", NumArgs: 1, Repr: 3, Modes: modes{ModeAny}},
+		"mult":   inst{Name: "mult", NumArgs: 1, Repr: 14, Modes: modes{ModeAny}},
+		"read":   inst{Name: "read", NumArgs: 1, Repr: 12, Modes: modes{ModeMemory | ModeRegister}},
 		"ret":    inst{Name: "ret", NumArgs: 0, Repr: 16, Flags: InstStack},
-		"stop":   inst{Name: "stop", NumArgs: 0, Repr: 11, Flags: 0},
-		"store":  inst{Name: "store", NumArgs: 1, Repr: 7, Flags: 0},
-		"sub":    inst{Name: "sub", NumArgs: 1, Repr: 6, Flags: InstImmediateA},
-		"write":  inst{Name: "write", NumArgs: 1, Repr: 8, Flags: InstImmediateA},
-		"push":   inst{Name: "push", NumArgs: 1, Repr: 17, Flags: InstStack},
-		"pop":    inst{Name: "pop", NumArgs: 1, Repr: 18, Flags: InstStack},
-		"call":   inst{Name: "call", NumArgs: 1, Repr: 15, Flags: InstStack | InstDirectIsImmediate},
+		"stop":   inst{Name: "stop", NumArgs: 0, Repr: 11},
+		"store":  inst{Name: "store", NumArgs: 1, Repr: 7, Modes: modes{ModeMemory | ModeRegister}},
+		"sub":    inst{Name: "sub", NumArgs: 1, Repr: 6, Modes: modes{ModeAny}},
+		"write":  inst{Name: "write", NumArgs: 1, Repr: 8, Modes: modes{ModeAny}},
+		"push":   inst{Name: "push", NumArgs: 1, Repr: 17, Flags: InstStack, Modes: modes{ModeAny}},
+		"pop":    inst{Name: "pop", NumArgs: 1, Repr: 18, Flags: InstStack, Modes: modes{ModeMemory | ModeRegister}},
+		"call":   inst{Name: "call", NumArgs: 1, Repr: 15, Flags: InstStack | InstDirectIsImmediate, Modes: modes{ModeMemory | ModeImmediate | ModeRelative}},
 	}
 }
 
@@ -77,6 +145,9 @@ func registerMap1Handler(
 	return func(s *Sim, args []MachineWord) {
 		opword := args[0]
 		vals := s.ResolveAddressMode(opword, args[1:])
+		if vals == nil {
+			return
+		}
 		reg := &s.Registers[regAddress]
 		*reg = mapf(s, *reg, *vals[0])
 	}
@@ -89,6 +160,9 @@ func registerMap2Handler(
 	return func(s *Sim, args []MachineWord) {
 		opword := args[0]
 		vals := s.ResolveAddressMode(opword, args[1:])
+		if vals == nil {
+			return
+		}
 		reg := &s.Registers[regAddress]
 		*reg = mapf(s, *reg, *vals[0], *vals[1])
 	}
@@ -98,6 +172,9 @@ func mutateState1Handler(callback func(*Sim, *MachineWord)) InstHandler {
 	return func(s *Sim, args []MachineWord) {
 		opword := args[0]
 		vals := s.ResolveAddressMode(opword, args[1:])
+		if vals == nil {
+			return
+		}
 		callback(s, vals[0])
 	}
 }
@@ -106,6 +183,9 @@ func mutateState2Handler(callback func(*Sim, *MachineWord, *MachineWord)) InstHa
 	return func(s *Sim, args []MachineWord) {
 		opword := args[0]
 		vals := s.ResolveAddressMode(opword, args[1:])
+		if vals == nil {
+			return
+		}
 		callback(s, vals[0], vals[1])
 	}
 }
@@ -127,6 +207,10 @@ func InstHandlers() map[string]InstHandler {
 		"divide": registerMap1Handler(
 			RegACC,
 			func(s *Sim, acc MachineWord, value MachineWord) MachineWord {
+				if value == 0 {
+					s.Raise(FaultDivideByZero, value)
+					return acc
+				}
 				return acc / value
 
 			}),
@@ -186,37 +270,30 @@ func InstHandlers() map[string]InstHandler {
 			*l = *r
 		}),
 		"push": mutateState1Handler(func(s *Sim, value *MachineWord) {
-			s.Mem.Work[s.GetRegister(RegSP)] = *value
-			s.MapRegister(RegSP, func(valueSP MachineWord) MachineWord { return valueSP + 1 })
+			s.pushWord(*value)
 		}),
 		"pop": mutateState1Handler(func(s *Sim, value *MachineWord) {
-			if !(s.GetRegister(RegSP) == (MachineWord(len(s.Mem.Work)) / 2)) {
-				s.MapRegister(RegSP, func(valueSP MachineWord) MachineWord { return valueSP - 1 })
+			if word, ok := s.popWord(); ok {
+				*value = word
 			}
-			*value = s.Mem.Work[s.GetRegister(RegSP)]
-			s.Mem.Work[s.GetRegister(RegSP)] = 0
 		}),
 		"call": mutateState1Handler(func(s *Sim, value *MachineWord) {
-			s.Mem.Work[s.GetRegister(RegSP)] = s.GetRegister(RegPC)
-			s.MapRegister(RegSP, func(valueSP MachineWord) MachineWord { return valueSP + 1 })
-			s.SetRegister(RegPC, *value)
-
+			s.pushWord(s.GetRegister(RegPC))
+			if !s.Faulted() {
+				s.SetRegister(RegPC, *value)
+			}
 		}),
 		"ret": mutateState1Handler(func(s *Sim, value *MachineWord) {
-			if !(s.GetRegister(RegSP) == (MachineWord(len(s.Mem.Work)) / 2)) {
-				s.MapRegister(RegSP, func(valueSP MachineWord) MachineWord { return valueSP - 1 })
+			if pc, ok := s.popWord(); ok {
+				s.SetRegister(RegPC, pc)
 			}
-			s.SetRegister(RegPC, s.Mem.Work[s.GetRegister(RegSP)])
-			s.Mem.Work[s.GetRegister(RegSP)] = 0
-
 		}),
 		"read": mutateState1Handler(func(s *Sim, value *MachineWord) {
-			s.State = SimStateIOBlocked
-			if len(s.InWords) > 0 {
-			  var word = s.RxInWord()
-			  *value = word
-				s.State = SimStateRun
-		  }
+			if len(s.InWords) == 0 {
+				s.State = SimStateIOBlocked
+				return
+			}
+			*value = s.RxInWord()
 		}),
 		"write": mutateState1Handler(func(s *Sim, value *MachineWord) {
 			var word = *value
@@ -5,8 +5,9 @@ import (
 	"dubcc"
 	"dubcc/assembler"
 	"fmt"
+	"slices"
 	"sort"
-	"github.com/k0kubun/pp/v3"
+	"strings"
 )
 
 type ObjectFile = assembler.ObjectFile
@@ -17,6 +18,7 @@ type SectionHeader = assembler.SectionHeader
 type Symbol = assembler.Symbol
 type SymbolBinding = assembler.SymbolBinding
 type Relocation = assembler.Relocation
+type Archive = assembler.Archive
 
 
 const STT_FUNC = assembler.STT_FUNC
@@ -30,7 +32,8 @@ const (
 )
 
 const (
-	R_ABSOLUTE = 1
+	R_ABSOLUTE = assembler.R_ABSOLUTE
+	R_RELATIVE = assembler.R_RELATIVE
 )
 
 type Linker struct {
@@ -39,27 +42,63 @@ type Linker struct {
 	Objects       []*ObjectFile
 	Executable    *ObjectFile
 	SectionMap    map[string]*LinkedSection // section key -> linked section
-	SymbolMap     map[string]*LinkedSymbol  // symbol name -> resolved symbol
+	SymbolMap     map[string]*LinkedSymbol  // global symbol name -> resolved symbol
+	Symbols       []*LinkedSymbol           // every defined symbol, locals included
 	SectionLayout []SectionInfo             // ordered list of sections with addresses
+	ObjectNames   []string                  // names of Objects, used in diagnostics
+	Archives      []*Archive                // searched in order for undefined symbols
+	ArchiveNames  []string
+	EntrySymbol   string                    // symbol to start at, "" to use start or main
+	Script        *Script                   // memory layout, nil to put sections one after another
+	Applied       []AppliedRelocation       // relocations patched into the executable
+	Diags         dubcc.Diagnostics
+	outputs       []*outputSection
 }
 
 type LinkedSection struct {
 	Section      *Section
 	ObjectIndex  int
-	BaseAddress  MachineAddress // relative to start of executable
-	AbsAddress   MachineAddress // absolute address if in absolute mode
+	BaseAddress  MachineAddress // relative to start of executable, in words
+	AbsAddress   MachineAddress // absolute address if in absolute mode, in words
 	Size         uint32
-	SectionIndex int 						// index in final executable
+	SectionIndex int 						// index of the output section in the final executable
+	Offset       MachineAddress // words from the start of the output section
+}
+
+// Seção do executável, juntando as seções de mesmo nome dos objetos
+type outputSection struct {
+	Name    string
+	Type    assembler.DulfSection
+	Flags   uint32
+	Inputs  []*LinkedSection
+	Size    MachineAddress // in words
+	Address MachineAddress // relative to LoadAddress
+	Align   MachineAddress
 }
 
 type LinkedSymbol struct {
+	Name        string
 	Symbol      *Symbol
-	ObjectIndex int
+	ObjectIndex int            // -1 for symbols of the linker script
 	RelAddress  MachineAddress // relative address within executable
 	AbsAddress  MachineAddress // absolute address if in absolute mode
 	Section     string
 }
 
+// Relocação já aplicada, para o mapa do linker
+type AppliedRelocation struct {
+	ObjectIndex  int
+	Section      string         // output section of the patched word
+	SectionIndex int
+	Offset       MachineAddress // of the patched word in its output section
+	Address      MachineAddress // of the patched word
+	Symbol       string
+	Value        MachineAddress // written into the word
+	Addend       int64
+	Type         assembler.RelocationType
+	target       *LinkedSymbol
+}
+
 type SectionInfo struct {
 	Name        string
 	RelAddress  MachineAddress
@@ -81,23 +120,100 @@ func MakeAbsoluteLinker(loadAddress MachineAddress) *Linker {
 	}
 }
 
+// Absolute linker that places sections where the script says
+func MakeScriptLinker(script *Script) *Linker {
+	return &Linker{
+		Mode:   Absolute,
+		Script: script,
+	}
+}
+
 func (linker *Linker) GenerateExecutable(objects []*ObjectFile) (*ObjectFile, error) {
 	// layout sections and build symbol table
-	linker.Objects = objects
+	linker.Objects = slices.Clip(objects)
+	linker.pullArchiveMembers()
 	linker.SectionMap = make(map[string]*LinkedSection)
 	linker.SymbolMap = make(map[string]*LinkedSymbol)
+	linker.Symbols = nil
+	linker.SectionLayout = nil
+	linker.Applied = nil
+	linker.Diags = nil
 
 	if err := linker.firstPass(); err != nil {
-		return nil, fmt.Errorf("failed at pass 1: %v", err)
+		linker.errorf(-1, "failed at pass 1: %v", err)
+	}
+	if err := linker.Diags.Err(); err != nil {
+		return nil, err
 	}
 
 	if err := linker.secondPass(); err != nil {
-		return nil, fmt.Errorf("failed at pass 2: %v", err)
+		linker.errorf(-1, "failed at pass 2: %v", err)
+	}
+	if err := linker.Diags.Err(); err != nil {
+		return nil, err
 	}
 
 	return linker.Executable, nil
 }
 
+func (linker *Linker) AddArchive(name string, ar *Archive) {
+	linker.Archives = append(linker.Archives, ar)
+	linker.ArchiveNames = append(linker.ArchiveNames, name)
+}
+
+// Adds to Objects the archive members that define a symbol still
+// undefined, then looks up the symbols those members need, until nothing
+// more can be resolved. Missing symbols are reported later as undefined.
+func (linker *Linker) pullArchiveMembers() {
+	defined := make(map[string]bool)
+	var undefined []string
+	add := func(obj *ObjectFile) {
+		for _, name := range assembler.DefinedGlobals(obj) {
+			defined[name] = true
+		}
+		undefined = append(undefined, assembler.UndefinedSymbols(obj)...)
+	}
+	for _, obj := range linker.Objects {
+		add(obj)
+	}
+
+	for i := 0; i < len(undefined); i++ {
+		name := undefined[i]
+		if defined[name] {
+			continue
+		}
+		for arIdx, ar := range linker.Archives {
+			memberIdx, found := ar.Index[name]
+			if !found {
+				continue
+			}
+			for len(linker.ObjectNames) < len(linker.Objects) {
+				linker.ObjectNames = append(linker.ObjectNames, linker.objectName(len(linker.ObjectNames)))
+			}
+			member := ar.Members[memberIdx]
+			linker.Objects = append(linker.Objects, member.Object)
+			linker.ObjectNames = append(linker.ObjectNames, fmt.Sprintf("%s(%s)", linker.ArchiveNames[arIdx], member.Name))
+			add(member.Object)
+			break
+		}
+	}
+}
+
+func (linker *Linker) objectName(objIdx int) string {
+	if objIdx >= 0 && objIdx < len(linker.ObjectNames) {
+		return linker.ObjectNames[objIdx]
+	}
+	if objIdx >= 0 {
+		return fmt.Sprintf("object %d", objIdx)
+	}
+	return "linker"
+}
+
+// Records a problem with object objIdx (-1 for the link as a whole)
+func (linker *Linker) errorf(objIdx int, format string, args ...any) {
+	linker.Diags.Errorf(linker.objectName(objIdx), 0, 0, format, args...)
+}
+
 func (linker *Linker) firstPass() error {
 	// calculate section layout
 	if err := linker.calculateSectionLayout(); err != nil {
@@ -115,55 +231,137 @@ func (linker *Linker) firstPass() error {
 	return nil
 }
 
-func (linker *Linker) calculateSectionLayout() error {
-	var currentRelAddress MachineAddress = 0
-	var currentAbsAddress MachineAddress = linker.LoadAddress
+func sectionKey(objIdx int, name string) string {
+	return fmt.Sprintf("%d:%s", objIdx, name)
+}
+
+// Order of the output sections: code, then data, then bss
+func (out *outputSection) rank() int {
+	switch {
+	case out.Type == assembler.SHT_NOBITS:
+		return 2
+	case out.Flags&assembler.SHF_EXECINSTR != 0:
+		return 0
+	default:
+		return 1
+	}
+}
 
-	// process .text sections in order
+// Merges like-named sections of all objects, in object order, and gives
+// every input section its address
+func (linker *Linker) calculateSectionLayout() error {
+	var outputs []*outputSection
 	for objIdx, obj := range linker.Objects {
-		for _, section := range obj.Sections {
-			if section.Name == ".text" {
-				sectionInfo := SectionInfo{
-					Name:					section.Name,
-					RelAddress: 	currentRelAddress,
-					AbsAddress: 	currentAbsAddress,
-					Size:					section.Header.Size,
-					ObjectIndex: 	objIdx,
-				}
-				linker.SectionLayout = append(linker.SectionLayout, sectionInfo)
-
-				// section mapping
-				sectionKey := fmt.Sprintf("%d:.text", objIdx)
-				linker.SectionMap[sectionKey] = &LinkedSection{
-					Section:			&section,
-					ObjectIndex:  objIdx,
-					BaseAddress:  currentRelAddress, 						// relative to start of executable
-					AbsAddress:   currentAbsAddress, 						// absolute address if in absolute mode
-					Size:        	section.Header.Size,
-					SectionIndex:	len(linker.SectionLayout) - 1,	// index in final executable
-				}
+		for idx := range obj.Sections {
+			section := &obj.Sections[idx]
+			outIdx := slices.IndexFunc(outputs, func(out *outputSection) bool {
+				return out.Name == section.Name
+			})
+			if outIdx < 0 {
+				outputs = append(outputs, &outputSection{
+					Name:  section.Name,
+					Type:  section.Header.Type,
+					Flags: section.Header.Flags,
+				})
+				outIdx = len(outputs) - 1
+			}
+			out := outputs[outIdx]
+			if out.Type != section.Header.Type {
+				linker.errorf(objIdx, "section %s has type %d, other objects have type %d",
+					section.Name, section.Header.Type, out.Type)
+				continue
+			}
 
-				// advance addresses
-				sectionSizeWords := (section.Header.Size + 1) / 2 * 2 // word boundary
-				currentRelAddress += MachineAddress(sectionSizeWords)
-				currentAbsAddress += MachineAddress(sectionSizeWords)
+			linked := &LinkedSection{
+				Section:     section,
+				ObjectIndex: objIdx,
+				Size:        section.Header.Size,
+				Offset:      out.Size,
 			}
+			out.Inputs = append(out.Inputs, linked)
+			out.Size += MachineAddress(section.Header.Size / 2) // Size is in bytes
+			linker.SectionMap[sectionKey(objIdx, section.Name)] = linked
 		}
 	}
+	slices.SortStableFunc(outputs, func(a, b *outputSection) int {
+		return a.rank() - b.rank()
+	})
+
+	if linker.Script != nil {
+		linker.placeSections(outputs)
+	} else {
+		var currentRelAddress MachineAddress = 0
+		for _, out := range outputs {
+			out.Address = currentRelAddress
+			currentRelAddress += out.Size
+		}
+	}
+
+	for outIdx, out := range outputs {
+		for _, linked := range out.Inputs {
+			linked.SectionIndex = outIdx
+			linked.BaseAddress = out.Address + linked.Offset
+			linked.AbsAddress = linker.LoadAddress + linked.BaseAddress
+			linker.SectionLayout = append(linker.SectionLayout, SectionInfo{
+				Name:        out.Name,
+				RelAddress:  linked.BaseAddress,
+				AbsAddress:  linked.AbsAddress,
+				Size:        linked.Size,
+				ObjectIndex: linked.ObjectIndex,
+			})
+		}
+	}
+	linker.outputs = outputs
 
 	return nil
 }
 
+// Puts each output section in the memory region of its placement, after
+// the sections already there
+func (linker *Linker) placeSections(outputs []*outputSection) {
+	next := make(map[string]MachineAddress)
+	for _, region := range linker.Script.Regions {
+		next[region.Name] = region.Origin
+	}
+	for _, out := range outputs {
+		placement, found := linker.Script.Placement(out.Name)
+		if !found {
+			linker.Diags.Errorf(linker.Script.Name, 0, 0, "no placement for section %s", out.Name)
+			continue
+		}
+		region, found := linker.Script.Region(placement.Region)
+		if !found {
+			linker.Diags.Errorf(linker.Script.Name, placement.Line, 0, "section %s goes to region %s, which is not declared",
+				out.Name, placement.Region)
+			continue
+		}
+
+		// a Script not made by ParseScript may leave Align at 0
+		out.Align = max(placement.Align, 1)
+		out.Address = (next[region.Name] + out.Align - 1) / out.Align * out.Align
+		next[region.Name] = out.Address + out.Size
+		if next[region.Name] > region.End() {
+			linker.Diags.Errorf(linker.Script.Name, placement.Line, 0, "section %s (%d words) overflows region %s [0x%04x, 0x%04x) by %d words",
+				out.Name, out.Size, region.Name, region.Origin, region.End(),
+				next[region.Name]-region.End())
+		}
+	}
+}
+
+// Input section holding an object's symbol, nil if undefined
+func (linker *Linker) symbolSection(objIdx int, symbol *Symbol) *LinkedSection {
+	obj := linker.Objects[objIdx]
+	if symbol.Section == assembler.SHN_UNDEF || int(symbol.Section) >= len(obj.Sections) {
+		return nil
+	}
+	return linker.SectionMap[sectionKey(objIdx, obj.Sections[symbol.Section].Name)]
+}
+
 func (linker *Linker) buildGlobalSymbolTable() error {
 	// collect all defined symbols
 	for objIdx, obj := range linker.Objects {
-		// find the section base address for the object
-		sectionKey := fmt.Sprintf("%d:.text", objIdx)
-		linkedSection, exists := linker.SectionMap[sectionKey]
-		if !exists {
-			continue // has no .text section
-		}
-		for _, symbol := range obj.Symbols {
+		for idx := range obj.Symbols {
+			symbol := &obj.Symbols[idx]
 			symbolName := obj.GetString(symbol.NameOffset)
 
 			// skip empty symbol names
@@ -171,46 +369,113 @@ func (linker *Linker) buildGlobalSymbolTable() error {
 				continue
 			}
 			// only process defined symbols (not external/undefined)
-			if symbol.Section != 0xFFF1 { // TODO: what is the undefsym code?
-				// collect all defined symbols with their resolved address
-				existing, exists := linker.SymbolMap[symbolName]
-				conflicting := exists
-				conflicting = conflicting && symbol.GetBinding() == assembler.STB_GLOBAL && existing.Symbol.GetBinding() == assembler.STB_GLOBAL
-				if conflicting {
-					return fmt.Errorf("symbol '%s' defined in multiple objects (obj %d and obj %d)\n%s | %s", 
-						symbolName, existing.ObjectIndex, objIdx,
-						pp.Sprint(symbol), pp.Sprint(existing.Symbol))
-				}
+			linkedSection := linker.symbolSection(objIdx, symbol)
+			if linkedSection == nil {
+				continue
+			}
 
-				relAddress := linkedSection.BaseAddress/2 + symbol.Value
-				absAddress := linkedSection.AbsAddress/2 + symbol.Value
-        
-				fmt.Printf(
-`Symbol %s got
-relative address = %d + %d = %d and 
-absolute address = %d + %d = %d
-`,
-				symbolName,
-				linkedSection.BaseAddress/2, symbol.Value, 
-				linkedSection.BaseAddress/2 + symbol.Value,
-        linkedSection.AbsAddress/2, symbol.Value,
-				linkedSection.AbsAddress/2 + symbol.Value,
-			  )
-
-				linker.SymbolMap[symbolName] = &LinkedSymbol{
-					Symbol:      &symbol,
-					ObjectIndex: objIdx,
-					RelAddress:  relAddress,
-					AbsAddress:  absAddress,
-					Section:     ".text",
-				}
+			linked := &LinkedSymbol{
+				Name:        symbolName,
+				Symbol:      symbol,
+				ObjectIndex: objIdx,
+				RelAddress:  linkedSection.BaseAddress + symbol.Value,
+				AbsAddress:  linkedSection.AbsAddress + symbol.Value,
+				Section:     linkedSection.Section.Name,
+			}
+			linker.Symbols = append(linker.Symbols, linked)
+			if symbol.GetBinding() != assembler.STB_GLOBAL {
+				continue
+			}
+
+			// collect all global symbols with their resolved address
+			if existing, exists := linker.SymbolMap[symbolName]; exists {
+				linker.errorf(objIdx, "symbol '%s' already defined in %s",
+					symbolName, linker.objectName(existing.ObjectIndex))
+				continue
 			}
+			linker.SymbolMap[symbolName] = linked
 		}
 	}
+	if linker.Script != nil {
+		linker.defineScriptSymbols()
+	}
 
 	return nil
 }
 
+// Adds the symbols of the linker script as absolute globals
+func (linker *Linker) defineScriptSymbols() {
+	for _, scriptSym := range linker.Script.Symbols {
+		value, err := linker.scriptValue(scriptSym)
+		if err != nil {
+			linker.Diags.Errorf(linker.Script.Name, scriptSym.Line, 0, "symbol %s: %v", scriptSym.Name, err)
+			continue
+		}
+		if existing, exists := linker.SymbolMap[scriptSym.Name]; exists {
+			linker.Diags.Errorf(linker.Script.Name, scriptSym.Line, 0, "symbol '%s' already defined in %s",
+				scriptSym.Name, linker.objectName(existing.ObjectIndex))
+			continue
+		}
+
+		linked := &LinkedSymbol{
+			Name:        scriptSym.Name,
+			Symbol:      &Symbol{Value: value, Section: assembler.SHN_ABS},
+			ObjectIndex: -1,
+			RelAddress:  value,
+			AbsAddress:  value,
+		}
+		linked.Symbol.SetInfo(assembler.STB_GLOBAL, assembler.STT_NOTYPE)
+		linker.Symbols = append(linker.Symbols, linked)
+		linker.SymbolMap[scriptSym.Name] = linked
+	}
+}
+
+func (linker *Linker) scriptValue(scriptSym ScriptSymbol) (MachineAddress, error) {
+	value := scriptSym.Offset
+	if scriptSym.Ref != "" {
+		dot := strings.LastIndex(scriptSym.Ref, ".")
+		name, attr := scriptSym.Ref[:dot], scriptSym.Ref[dot+1:]
+
+		var start, size MachineAddress
+		if region, found := linker.Script.Region(name); found {
+			start, size = region.Origin, region.Length
+		} else if idx := slices.IndexFunc(linker.outputs, func(out *outputSection) bool {
+			return out.Name == name
+		}); idx >= 0 {
+			start, size = linker.outputs[idx].Address, linker.outputs[idx].Size
+		} else {
+			return 0, fmt.Errorf("%s is neither a region nor a section", name)
+		}
+
+		switch attr {
+		case "start":
+			value += int64(start)
+		case "end":
+			value += int64(start + size)
+		case "size":
+			value += int64(size)
+		}
+	}
+	if value < 0 || value >= 1<<16 {
+		return 0, fmt.Errorf("value %d is outside of memory", value)
+	}
+	return MachineAddress(value), nil
+}
+
+// Address a symbol of object objIdx refers to: its own definition if it
+// has one, the global definition otherwise
+func (linker *Linker) resolveSymbol(objIdx int, symbol *Symbol) (*LinkedSymbol, bool) {
+	if linker.symbolSection(objIdx, symbol) != nil {
+		for _, linked := range linker.Symbols {
+			if linked.Symbol == symbol {
+				return linked, true
+			}
+		}
+	}
+	linked, found := linker.SymbolMap[linker.Objects[objIdx].GetString(symbol.NameOffset)]
+	return linked, found
+}
+
 func (linker *Linker) verifySymbolResolution() error {
 	// check that all undefined symbols can be resolved
 	for objIdx, obj := range linker.Objects {
@@ -223,9 +488,9 @@ func (linker *Linker) verifySymbolResolution() error {
 			}
 
 			// check undefined symbols
-			if symbol.Section == 0xFFF1 { // TODO: what is the undefsym code?
+			if symbol.Section == assembler.SHN_UNDEF {
 				if _, exists := linker.SymbolMap[symbolName]; !exists {
-					return fmt.Errorf("undefined symbol '%s' referenced in object %d", symbolName, objIdx)
+					linker.errorf(objIdx, "undefined symbol '%s'", symbolName)
 				}
 			}
 		}
@@ -240,7 +505,6 @@ func (linker *Linker) secondPass() error {
 		return err
 	}
 
-	pp.Print(linker.SectionMap)
 	// apply relocations
 	if  err := linker.applyRelocations(); err != nil {
 		return err
@@ -259,96 +523,99 @@ func (linker *Linker) createExecutableStructure() error {
 	}
 	linker.Executable.AddString("") // empty string at offset 0
 
-	// merge all .text sections
-	var mergedTextData []MachineWord
-	totalSize := uint32(0)
-
-	for _, sectionInfo := range linker.SectionLayout {
-		obj := linker.Objects[sectionInfo.ObjectIndex]
-		for _, section := range obj.Sections {
-			if section.Name == ".text" {
-				mergedTextData = append(mergedTextData, section.Data...)
-				totalSize += section.Header.Size
-				break
+	// one output section per name, in layout order
+	for _, out := range linker.outputs {
+		address := out.Address
+		if linker.Mode == Absolute {
+			address += linker.LoadAddress
+		}
+		merged := Section{
+			Name: out.Name,
+			Header: SectionHeader{
+				Type:      out.Type,
+				Flags:     out.Flags,
+				Size:      uint32(out.Size * 2),
+				Address:   address,
+				Alignment: uint32(out.Align),
+			},
+		}
+		if out.Type != assembler.SHT_NOBITS {
+			for _, input := range out.Inputs {
+				merged.Data = append(merged.Data, input.Section.Data...)
 			}
 		}
+		merged.Header.NameOffset = linker.Executable.AddString(out.Name)
+		linker.Executable.Sections = append(linker.Executable.Sections, merged)
 	}
 
-	// create merged .text section
-	mergedSection := Section{
-		Name: ".text",
-		Header: SectionHeader{
-			Type:    SHT_PROGBITS,
-			Flags:   0x6, // allocatable + executable
-			Size:    totalSize,
-			Address: 0,
-			Offset:  0,
-		},
-		Data: mergedTextData,
-	}
-
-	// set section address based on linking mode
-	switch linker.Mode {
-	case Absolute:
-		mergedSection.Header.Address = linker.LoadAddress
-	case Relocator:
-		mergedSection.Header.Address = 0 // relocatable
-	}
-
-	mergedSection.Header.NameOffset = linker.Executable.AddString(".text")
-	linker.Executable.Sections = []Section{mergedSection}
-
 	return nil
 
 }
 
 func (linker *Linker) applyRelocations() error {
 	// process relocations from each object file
-	var currentDataOffset uint32 = 0
-
 	for objIdx, obj := range linker.Objects {
-		// calculate where this object's data starts in the merged section
-			if objIdx > 0 {
-				for i := range objIdx {
-				for _, section := range linker.Objects[i].Sections {
-					currentDataOffset += section.Header.Size / 2
-				}
-			}
-		}
-
 		for _, reloc := range obj.Relocations {
 			// find target symbol
 			symbi := reloc.GetSymbolIndex()
-			symbName := obj.GetString(obj.Symbols[symbi].NameOffset)
-			linkedSymbol, exists := linker.SymbolMap[symbName]
+			if int(symbi) >= len(obj.Symbols) {
+				linker.errorf(objIdx, "relocation at %d uses symbol %d, object has %d symbols",
+					reloc.Offset, symbi, len(obj.Symbols))
+				continue
+			}
+			symbol := &obj.Symbols[symbi]
+			symbName := obj.GetString(symbol.NameOffset)
+			linkedSymbol, exists := linker.resolveSymbol(objIdx, symbol)
 			if !exists {
-				return fmt.Errorf("cannot resolve relocation for symbol '%s'", symbName)
+				linker.errorf(objIdx, "cannot resolve relocation for symbol '%s'", symbName)
+				continue
 			}
 
-			// calculate relocation position in merged data
-			relocPosition := MachineAddress(currentDataOffset) + reloc.Offset
-			
-			if relocPosition >= MachineAddress(len(linker.Executable.Sections[0].Data)) {
-				return fmt.Errorf("relocation position %d out of bounds (max %d)", 
-					relocPosition, len(linker.Executable.Sections[0].Data))
+			// where the relocated word ended up
+			if int(reloc.Section) >= len(obj.Sections) {
+				linker.errorf(objIdx, "relocation at %d is in section %d, object has %d sections",
+					reloc.Offset, reloc.Section, len(obj.Sections))
+				continue
 			}
+			input := linker.SectionMap[sectionKey(objIdx, obj.Sections[reloc.Section].Name)]
+			output := &linker.Executable.Sections[input.SectionIndex]
+			relocPosition := input.Offset + reloc.Offset
 
-			// apply relocation based on linking mode
-			var targetAddress MachineAddress
-			switch linker.Mode {
-			case Absolute:
-				targetAddress = linkedSymbol.AbsAddress
-			case Relocator:
-				targetAddress = linkedSymbol.RelAddress
+			if relocPosition >= MachineAddress(len(output.Data)) {
+				linker.errorf(objIdx, "relocation position %d out of bounds of %s (max %d)",
+					relocPosition, output.Name, len(output.Data))
+				continue
 			}
 
-			// apply the relocation
-			switch reloc.GetType() {
+			address := input.BaseAddress + reloc.Offset
+			if linker.Mode == Absolute {
+				address = input.AbsAddress + reloc.Offset
+			}
+
+			// apply the relocation: S + A, or S + A - P for PC relative
+			value := int64(linker.symbolAddress(linkedSymbol)) + reloc.Addend
+			switch reloc.RelocType() {
 			case R_ABSOLUTE:
-				linker.Executable.Sections[0].Data[relocPosition] = uint16(targetAddress & 0xFFFF)
+			case R_RELATIVE:
+				value -= int64(address)
 			default:
-				return fmt.Errorf("unsupported relocation type: %d", reloc.GetType())
+				linker.errorf(objIdx, "unsupported relocation type: %d", reloc.GetType())
+				continue
 			}
+			output.Data[relocPosition] = uint16(value & 0xFFFF)
+
+			linker.Applied = append(linker.Applied, AppliedRelocation{
+				ObjectIndex:  objIdx,
+				Section:      output.Name,
+				SectionIndex: input.SectionIndex,
+				Offset:       relocPosition,
+				Address:      address,
+				Symbol:       symbName,
+				Value:        MachineAddress(output.Data[relocPosition]),
+				Addend:       reloc.Addend,
+				Type:         reloc.RelocType(),
+				target:       linkedSymbol,
+			})
 		}
 	}
 
@@ -361,17 +628,15 @@ func (linker *Linker) finalizeBinary() error {
 	var finalSymbols []Symbol
 	
 	// sort symbols by address
-	var sortedSymbols []*LinkedSymbol
-	for _, linkedSym := range linker.SymbolMap {
-		sortedSymbols = append(sortedSymbols, linkedSym)
-	}
-	
-	sort.Slice(sortedSymbols, func(i, j int) bool {
+	sortedSymbols := slices.Clone(linker.Symbols)
+	sort.SliceStable(sortedSymbols, func(i, j int) bool {
 		return sortedSymbols[i].RelAddress < sortedSymbols[j].RelAddress
 	})
 
+	symbolIndex := make(map[*LinkedSymbol]uint32)
 	for _, linkedSym := range sortedSymbols {
-		symbolName := linker.Objects[linkedSym.ObjectIndex].GetString(linkedSym.Symbol.NameOffset)
+		symbolIndex[linkedSym] = uint32(len(finalSymbols))
+		symbolName := linkedSym.Name
 		
 		// include all defined symbols
 		var symbolValue MachineAddress
@@ -386,26 +651,114 @@ func (linker *Linker) finalizeBinary() error {
 			NameOffset: linker.Executable.AddString(symbolName),
 			Value:      symbolValue,
 			Size:       linkedSym.Symbol.Size,
-			Section:    0, // everything in .text
+			Section:    assembler.SHN_ABS,
+		}
+		if linkedSym.ObjectIndex >= 0 {
+			finalSym.Section = uint16(linker.symbolSection(linkedSym.ObjectIndex, linkedSym.Symbol).SectionIndex)
 		}
 
-		binding := linkedSym.Symbol.GetBinding()
-		finalSym.SetInfo(binding, STT_FUNC)
+		finalSym.SetInfo(linkedSym.Symbol.GetBinding(), linkedSym.Symbol.Type())
 		finalSymbols = append(finalSymbols, finalSym)
 	}
 
 	linker.Executable.Symbols = finalSymbols
 
+	// a relocatable executable keeps its relocations, rebased to the merged
+	// sections, so a relocating loader can move it (only R_ABSOLUTE words
+	// change, PC relative ones move with the code)
+	linker.Executable.Relocations = []Relocation{}
 	if linker.Mode == Relocator {
-		linker.Executable.Relocations = []Relocation{}
-	} else {
-		linker.Executable.Relocations = []Relocation{}
+		for _, applied := range linker.Applied {
+			reloc := Relocation{
+				Offset:  applied.Offset,
+				Addend:  applied.Addend,
+				Section: uint16(applied.SectionIndex),
+			}
+			reloc.SetInfo(symbolIndex[applied.target], applied.Type)
+			linker.Executable.Relocations = append(linker.Executable.Relocations, reloc)
+		}
+		linker.Executable.Header.Flags |= assembler.DF_RELOCATABLE
 	}
 
-	linker.Executable.Header.Magic = [4]byte{'D', 'U', 'L', 'F'}
+	linker.Executable.Header.Magic = assembler.DulfMagic
+	linker.Executable.Header.Kind = assembler.ET_EXEC
 	linker.Executable.Header.SectionCount = uint16(len(linker.Executable.Sections))
 	linker.Executable.Header.SymbolCount = uint16(len(linker.Executable.Symbols))
 	linker.Executable.Header.RelocCount = uint16(len(linker.Executable.Relocations))
 
+	entry, err := linker.resolveEntry()
+	if err != nil {
+		return err
+	}
+	linker.Executable.SetEntry(entry)
+
 	return nil
 }
+
+// Where the executable starts: EntrySymbol if given, otherwise the start
+// directive of the first object that has one, otherwise a global start or
+// main symbol, otherwise the beginning of the executable.
+func (linker *Linker) resolveEntry() (MachineAddress, error) {
+	if linker.EntrySymbol != "" {
+		sym, found := linker.SymbolMap[linker.EntrySymbol]
+		if !found {
+			return 0, fmt.Errorf("entry symbol '%s' is not defined", linker.EntrySymbol)
+		}
+		return linker.symbolAddress(sym), nil
+	}
+
+	for objIdx, obj := range linker.Objects {
+		if !obj.HasEntry() {
+			continue
+		}
+		// the entry is an address in the object's own layout
+		for _, section := range obj.Sections {
+			start := section.Header.Address
+			if obj.Header.Entry < start || obj.Header.Entry >= start+MachineAddress(section.Header.Size/2) {
+				continue
+			}
+			linked := linker.SectionMap[sectionKey(objIdx, section.Name)]
+			if linker.Mode == Absolute {
+				return linked.AbsAddress + obj.Header.Entry - start, nil
+			}
+			return linked.BaseAddress + obj.Header.Entry - start, nil
+		}
+		return 0, fmt.Errorf("%s: entry point 0x%04x is outside of its sections",
+			linker.objectName(objIdx), obj.Header.Entry)
+	}
+
+	// only globals: a local start or main label is private to its object
+	start, hasStart := linker.SymbolMap["start"]
+	main, hasMain := linker.SymbolMap["main"]
+	switch {
+	case hasStart && hasMain:
+		if linker.symbolAddress(start) != linker.symbolAddress(main) {
+			linker.Diags.Warnf(linker.objectName(-1), 0, 0,
+				"both start (%s) and main (%s) are global, starting at start; use --entry to choose",
+				linker.objectName(start.ObjectIndex), linker.objectName(main.ObjectIndex))
+		}
+		return linker.symbolAddress(start), nil
+	case hasStart:
+		return linker.symbolAddress(start), nil
+	case hasMain:
+		return linker.symbolAddress(main), nil
+	}
+	for _, sym := range linker.Symbols {
+		if sym.Name == "start" || sym.Name == "main" {
+			linker.Diags.Warnf(linker.objectName(sym.ObjectIndex), 0, 0,
+				"%s is local, not used as the entry point; make it global or use --entry", sym.Name)
+		}
+	}
+
+	if linker.Mode == Absolute {
+		return linker.LoadAddress, nil
+	}
+	return 0, nil
+}
+
+func (linker *Linker) symbolAddress(sym *LinkedSymbol) MachineAddress {
+	if linker.Mode == Absolute {
+		return sym.AbsAddress
+	}
+	return sym.RelAddress
+}
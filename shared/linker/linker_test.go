@@ -0,0 +1,438 @@
+package linker_test
+
+import (
+	"dubcc"
+	"dubcc/assembler"
+	"dubcc/linker"
+	"dubcc/macroprocessor"
+	"errors"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Assembles one module into a relocatable object
+func assemble(t *testing.T, name, source string) *assembler.ObjectFile {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	info, diags, err := macroprocessor.AssembleSource(name, source)
+	if err != nil {
+		t.Fatalf("assembling %s: %v", name, diags)
+	}
+	obj, err := info.GenerateObjectFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+// Links the sources, named m0.asm, m1.asm... in order
+func link(t *testing.T, l *linker.Linker, sources ...string) *assembler.ObjectFile {
+	t.Helper()
+	var objects []*assembler.ObjectFile
+	for i, source := range sources {
+		name := "m" + string(rune('0'+i)) + ".asm"
+		objects = append(objects, assemble(t, name, source))
+		l.ObjectNames = append(l.ObjectNames, name)
+	}
+	executable, err := l.GenerateExecutable(objects)
+	if err != nil {
+		t.Fatalf("linking: %v", err)
+	}
+	return executable
+}
+
+func hasWarning(diags dubcc.Diagnostics, text string) bool {
+	for _, diag := range diags {
+		if diag.Severity == dubcc.SeverityWarning && strings.Contains(diag.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		linker  *linker.Linker
+		entry   string // --entry
+		sources []string
+		want    dubcc.MachineAddress
+		warning string
+	}{
+		{"start directive of a later object", linker.MakeRelocatorLinker(), "",
+			[]string{"stop\nend", "stop\nstart go\ngo: stop\nend"}, 2, ""},
+		{"entry symbol", linker.MakeAbsoluteLinker(0x40), "go",
+			[]string{"stop\nextr go\ngo: stop\nend"}, 0x41, ""},
+		{"global main", linker.MakeRelocatorLinker(), "",
+			[]string{"stop\nend", "stop\nextr main\nmain: stop\nend"}, 2, ""},
+		{"local start is ignored", linker.MakeAbsoluteLinker(0x40), "",
+			[]string{"stop\nstart: stop\nend"}, 0x40, "start is local"},
+		{"start and main", linker.MakeRelocatorLinker(), "",
+			[]string{"extr main\nmain: stop\nend", "extr start\nstart: stop\nend"}, 1, "both start"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.linker.EntrySymbol = test.entry
+			executable := link(t, test.linker, test.sources...)
+			if executable.Header.Entry != test.want {
+				t.Errorf("entry 0x%04x, want 0x%04x", executable.Header.Entry, test.want)
+			}
+			if test.warning != "" && !hasWarning(test.linker.Diags, test.warning) {
+				t.Errorf("no warning %q in %v", test.warning, test.linker.Diags)
+			}
+		})
+	}
+}
+
+// m0 reaches f in m1 with an absolute load and a PC relative call, m1
+// branches back to g in m0. A relative word holds S + A - P, P being the
+// address of the word itself.
+var crossModule = []string{
+	"data\nv: const 7\ntext\nextr g\ng: load f\ncall f,R\nstop\nf: extdef\nend",
+	"extr f\nstop\nf: br g+1,R\ng: extdef\nend",
+}
+
+func TestRelativeRelocation(t *testing.T) {
+	// .text: m0 at 0 (5 words), m1 at 5; .data after the code
+	tests := []struct {
+		name   string
+		linker *linker.Linker
+		want   []dubcc.MachineWord
+	}{
+		{"absolute", linker.MakeAbsoluteLinker(0x40),
+			[]dubcc.MachineWord{0x0003, 0x0046, 0x040f, 0x0003, 0x000b, 0x000b, 0x0400, 0xfffa}},
+		{"relocator", linker.MakeRelocatorLinker(),
+			[]dubcc.MachineWord{0x0003, 0x0006, 0x040f, 0x0003, 0x000b, 0x000b, 0x0400, 0xfffa}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executable := link(t, test.linker, crossModule...)
+			text := executable.Sections[0]
+			if text.Name != ".text" || !slices.Equal(text.Data, test.want) {
+				t.Fatalf("%s: got %04x\nwant %04x", text.Name, text.Data, test.want)
+			}
+		})
+	}
+}
+
+// A relocatable executable keeps every relocation, rebased to the merged
+// sections, but only absolute words become fixups for the loader
+func TestRelocatorKeepsRelocations(t *testing.T) {
+	executable := link(t, linker.MakeRelocatorLinker(), crossModule...)
+	if executable.Header.Flags&assembler.DF_RELOCATABLE == 0 {
+		t.Error("executable isn't marked relocatable")
+	}
+
+	type reloc struct {
+		offset dubcc.MachineAddress
+		kind   assembler.RelocationType
+		symbol string
+		addend int64
+	}
+	var got []reloc
+	for _, r := range executable.Relocations {
+		if r.Section != 0 {
+			t.Errorf("relocation at %d in section %d, want .text", r.Offset, r.Section)
+		}
+		symbol := executable.Symbols[r.GetSymbolIndex()]
+		got = append(got, reloc{r.Offset, r.RelocType(), executable.GetString(symbol.NameOffset), r.Addend})
+	}
+	want := []reloc{
+		{1, assembler.R_ABSOLUTE, "f", 0},
+		{3, assembler.R_RELATIVE, "f", 0},
+		{7, assembler.R_RELATIVE, "g", 1},
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+
+	// loaded at 0x100, the absolute word moves and the relative ones don't
+	sim := dubcc.MakeSim(0x200)
+	if err := sim.LoadImageAt(executable.Image(), 0x100); err != nil {
+		t.Fatal(err)
+	}
+	if words := sim.Mem.Work[0x100:0x108]; !slices.Equal(words, []dubcc.MachineWord{
+		0x0003, 0x0106, 0x040f, 0x0003, 0x000b, 0x000b, 0x0400, 0xfffa}) {
+		t.Errorf("loaded at 0x100: %04x", words)
+	}
+}
+
+func parseScript(t *testing.T, text string) *linker.Script {
+	t.Helper()
+	script, err := linker.ParseScript("test.lds", strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestParseScriptErrors(t *testing.T) {
+	regions := "memory code 0x10 0x20\n"
+	tests := []struct {
+		name, text, want string
+	}{
+		{"unknown directive", regions + "  region data 0x100 8", "test.lds:2:3: error: unknown directive region"},
+		{"memory fields", "memory code 0x10", "test.lds:1:1: error: usage: memory <name> <origin> <length>"},
+		{"memory number", "memory code 0x10 lots", "test.lds:1:1: error: bad number in region code"},
+		{"duplicate region", regions + "memory code 0x100 8", "test.lds:2:1: error: region code already declared"},
+		{"overlapping region", regions + "\n\tmemory data 0x20 8", "test.lds:3:2: error: region data overlaps region code"},
+		{"place fields", regions + "place .text", "test.lds:2:1: error: usage: place <section> <region> [align <n>]"},
+		{"place align fields", regions + "place .text code 4", "test.lds:2:1: error: usage: place <section> <region> [align <n>]"},
+		{"bad pattern", regions + "place [ code", "test.lds:2:1: error: bad section pattern ["},
+		{"undeclared region", regions + "place .text ram ; ram comes later\nmemory ram 0x100 8",
+			"test.lds:2:1: error: region ram is not declared"},
+		{"zero alignment", regions + "place .text code align 0", "test.lds:2:1: error: alignment must be a positive number"},
+		{"symbol fields", "symbol top data.end", "test.lds:1:1: error: usage: symbol <name> = <value>"},
+		{"symbol value", "symbol top = data", "test.lds:1:1: error: data is neither a number nor <region>.<start|end|size>"},
+		{"symbol attribute", regions + " symbol mid = code.middle", "test.lds:2:2: error: unknown attribute middle, use start, end or size"},
+		{"symbol operator", regions + "symbol top = code.end * 2", "test.lds:2:1: error: symbol values are <value> [(+|-) <number>]"},
+		{"symbol offset", regions + "symbol top = code.end - x", "test.lds:2:1: error: bad number x"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := linker.ParseScript("test.lds", strings.NewReader(test.text))
+			if err == nil || err.Error() != test.want {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+
+	// every bad line is reported, not just the first
+	_, err := linker.ParseScript("test.lds", strings.NewReader("memory code\nplace .text code\nalign 4"))
+	var diags dubcc.Diagnostics
+	if !errors.As(err, &diags) || len(diags) != 3 {
+		t.Fatalf("got %v, want 3 errors", err)
+	}
+}
+
+func TestScriptPlacement(t *testing.T) {
+	sources := []string{
+		"text\nload v\nstop\ndata\nv: const 7\nbss\nb: space\nend",
+		"text\nstop\ndata\nw: const 8\nend",
+	}
+	// sizes: .text 4 words, .data 2, .bss 1
+	tests := []struct {
+		name   string
+		script *linker.Script
+		want   map[string]dubcc.MachineAddress
+		err    string
+	}{
+		{"regions and alignment", parseScript(t, "memory code 0x10 0x20\nmemory data 0x100 8\n"+
+			"place .text code\nplace .* data align 4\nsymbol top = data.end"),
+			map[string]dubcc.MachineAddress{".text": 0x10, ".data": 0x100, ".bss": 0x104, "v": 0x100, "w": 0x101, "top": 0x108}, ""},
+		{"zero alignment", &linker.Script{
+			Regions:    []linker.MemoryRegion{{Name: "all", Origin: 0x20, Length: 0x10}},
+			Placements: []linker.Placement{{Pattern: "*", Region: "all"}},
+		}, map[string]dubcc.MachineAddress{".text": 0x20, ".data": 0x24, ".bss": 0x26}, ""},
+		{"region overflow", parseScript(t, "memory code 0x10 0x20\nmemory data 0x100 4\n"+
+			"place .text code\nplace .* data align 4"),
+			nil, "test.lds:4: error: section .bss (1 words) overflows region data [0x0100, 0x0104) by 1 words"},
+		{"no placement", parseScript(t, "memory code 0x10 0x20\nplace .text code\nplace .data code"),
+			nil, "test.lds: error: no placement for section .bss"},
+		{"undeclared region", &linker.Script{
+			Name:       "test.lds",
+			Regions:    []linker.MemoryRegion{{Name: "all", Origin: 0x20, Length: 0x10}},
+			Placements: []linker.Placement{{Pattern: ".text", Region: "all"}, {Pattern: "*", Region: "ram", Align: 1, Line: 2}},
+		}, nil, "test.lds:2: error: section .data goes to region ram, which is not declared"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := linker.MakeScriptLinker(test.script)
+			var objects []*assembler.ObjectFile
+			for i, source := range sources {
+				objects = append(objects, assemble(t, "m"+string(rune('0'+i))+".asm", source))
+			}
+			executable, err := l.GenerateExecutable(objects)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("want error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]dubcc.MachineAddress)
+			for _, section := range executable.Sections {
+				got[section.Name] = section.Header.Address
+			}
+			for _, symbol := range executable.Symbols {
+				got[executable.GetString(symbol.NameOffset)] = symbol.Value
+			}
+			for name, want := range test.want {
+				if got[name] != want {
+					t.Errorf("%s at 0x%04x, want 0x%04x", name, got[name], want)
+				}
+			}
+			if text := executable.Sections[0].Data; text[1] != dubcc.MachineWord(got["v"]) {
+				t.Errorf("load v reads 0x%04x, v is at 0x%04x", text[1], got["v"])
+			}
+		})
+	}
+}
+
+func TestSectionLayout(t *testing.T) {
+	// sections out of order in the sources, not every object uses all three
+	sources := []string{
+		"bss\nb0: space\ndata\nd0: const 1\ntext\nt0: stop\nend",
+		"text\nt1: load d1b\nstop\ndata\nd1: const 2\nd1b: const 3\nend",
+		"data\nd2: const 4\nbss\nb2: space\nspace\nend",
+	}
+	type input struct {
+		name    string
+		object  int
+		address dubcc.MachineAddress
+		size    uint32 // bytes
+	}
+	// .text 4 words, then .data 4, then .bss 3, each in object order
+	wantLayout := []input{
+		{".text", 0, 0, 2}, {".text", 1, 1, 6}, {".text", 2, 4, 0}, // m2 has an empty .text
+		{".data", 0, 4, 2}, {".data", 1, 5, 4}, {".data", 2, 7, 2},
+		{".bss", 0, 8, 2}, {".bss", 2, 9, 4},
+	}
+	wantSymbols := map[string]dubcc.MachineAddress{
+		"t0": 0, "t1": 1, "d0": 4, "d1": 5, "d1b": 6, "d2": 7, "b0": 8, "b2": 9,
+	}
+
+	tests := []struct {
+		name   string
+		linker *linker.Linker
+		base   dubcc.MachineAddress
+	}{
+		{"relocator", linker.MakeRelocatorLinker(), 0},
+		{"absolute", linker.MakeAbsoluteLinker(0x40), 0x40},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executable := link(t, test.linker, sources...)
+
+			var layout []input
+			for _, info := range test.linker.SectionLayout {
+				if info.AbsAddress != test.base+info.RelAddress {
+					t.Errorf("%s of m%d at 0x%04x, relative 0x%04x", info.Name, info.ObjectIndex, info.AbsAddress, info.RelAddress)
+				}
+				layout = append(layout, input{info.Name, info.ObjectIndex, info.RelAddress, info.Size})
+			}
+			if !slices.Equal(layout, wantLayout) {
+				t.Errorf("layout %+v\nwant %+v", layout, wantLayout)
+			}
+
+			var names []string
+			for _, section := range executable.Sections {
+				names = append(names, section.Name)
+			}
+			if !slices.Equal(names, []string{".text", ".data", ".bss"}) {
+				t.Errorf("output sections %v", names)
+			}
+
+			for _, symbol := range executable.Symbols {
+				name := executable.GetString(symbol.NameOffset)
+				if want, found := wantSymbols[name]; !found || symbol.Value != test.base+want {
+					t.Errorf("%s = 0x%04x, want 0x%04x", name, symbol.Value, test.base+want)
+				}
+			}
+			if len(executable.Symbols) != len(wantSymbols) {
+				t.Errorf("%d symbols, want %d", len(executable.Symbols), len(wantSymbols))
+			}
+			// load d1b, rebased too
+			if word := executable.Sections[0].Data[2]; word != dubcc.MachineWord(test.base+6) {
+				t.Errorf("load d1b reads 0x%04x", word)
+			}
+		})
+	}
+}
+
+func makeArchive(t *testing.T, members map[string]string, order ...string) *assembler.Archive {
+	t.Helper()
+	var list []assembler.ArchiveMember
+	for _, name := range order {
+		list = append(list, assembler.ArchiveMember{Name: name, Object: assemble(t, name, members[name])})
+	}
+	ar, err := assembler.MakeArchive(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ar
+}
+
+// main needs a, a needs b1 and b2 (both in b.o), nothing needs c
+func TestArchiveMembers(t *testing.T) {
+	ar := makeArchive(t, map[string]string{
+		"a.o": "extr a\na: load b1\nadd b2\nret\nb1: extdef\nb2: extdef\nend",
+		"b.o": "extr b1\nextr b2\nb1: const 1\nb2: const 2\nend",
+		"c.o": "extr c\nc: stop\nend",
+	}, "c.o", "b.o", "a.o")
+
+	t.Run("pulled", func(t *testing.T) {
+		l := linker.MakeRelocatorLinker()
+		l.AddArchive("lib.a", ar)
+		executable := link(t, l, "call a\nstop\na: extdef\nend")
+
+		want := []string{"m0.asm", "lib.a(a.o)", "lib.a(b.o)"}
+		if !slices.Equal(l.ObjectNames, want) || len(l.Objects) != len(want) {
+			t.Fatalf("linked %v (%d objects), want %v", l.ObjectNames, len(l.Objects), want)
+		}
+		for _, symbol := range executable.Symbols {
+			if executable.GetString(symbol.NameOffset) == "c" {
+				t.Error("c.o was linked")
+			}
+		}
+		// m0 is 3 words, a.o 5, then b1 and b2
+		text := executable.Sections[0].Data
+		if text[1] != 3 || text[4] != 8 || text[6] != 9 {
+			t.Errorf("call a, load b1, add b2 read 0x%04x 0x%04x 0x%04x", text[1], text[4], text[6])
+		}
+	})
+
+	t.Run("undefined", func(t *testing.T) {
+		l := linker.MakeRelocatorLinker()
+		l.AddArchive("lib.a", ar)
+		l.ObjectNames = []string{"m0.asm"}
+		_, err := l.GenerateExecutable([]*assembler.ObjectFile{
+			assemble(t, "m0.asm", "call a\ncall z\nstop\na: extdef\nz: extdef\nend"),
+		})
+		if err == nil || !strings.Contains(err.Error(), "m0.asm: error: undefined symbol 'z'") {
+			t.Fatalf("want z undefined, got %v", err)
+		}
+		if want := []string{"m0.asm", "lib.a(a.o)", "lib.a(b.o)"}; !slices.Equal(l.ObjectNames, want) {
+			t.Errorf("linked %v, want %v", l.ObjectNames, want)
+		}
+	})
+}
+
+// Types and sizes inferred by the assembler survive the link
+func TestSymbolTypes(t *testing.T) {
+	executable := link(t, linker.MakeAbsoluteLinker(0x40),
+		"extr main\nmain: call f\nload buf\nl: stop\nf: extdef\n"+
+			"data\ntbl: const 1\nconst 2\nbss\nbuf: space\nspace\nspace\nend",
+		"extr f\nf: load 1\nret\nend")
+
+	type symbol struct {
+		kind    assembler.SymbolType
+		size    uint32
+		binding assembler.SymbolBinding
+	}
+	want := map[string]symbol{
+		"main": {assembler.STT_FUNC, 4, assembler.STB_GLOBAL},
+		"l":    {assembler.STT_FUNC, 1, assembler.STB_LOCAL},
+		"f":    {assembler.STT_FUNC, 3, assembler.STB_GLOBAL}, // call target in m1
+		"tbl":  {assembler.STT_DATA, 2, assembler.STB_LOCAL},
+		"buf":  {assembler.STT_DATA, 3, assembler.STB_LOCAL}, // in .bss
+	}
+	got := make(map[string]symbol)
+	for _, s := range executable.Symbols {
+		got[executable.GetString(s.NameOffset)] = symbol{s.Type(), s.Size, s.GetBinding()}
+	}
+	for name, w := range want {
+		if got[name] != w {
+			t.Errorf("%s: %+v, want %+v", name, got[name], w)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("symbols %v", got)
+	}
+}
@@ -0,0 +1,100 @@
+package linker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Writes the link map: input objects, where each of their sections went,
+// the global symbols and the relocations that were applied. Call it after
+// GenerateExecutable succeeds.
+func (linker *Linker) WriteMap(w io.Writer) error {
+	var b strings.Builder
+	mode := "relocator"
+	if linker.Mode == Absolute {
+		mode = "absolute"
+	}
+	fmt.Fprintf(&b, "DUBcc linker map\n\n")
+	fmt.Fprintf(&b, "mode %s, load address 0x%04x", mode, linker.LoadAddress)
+	if linker.Script != nil {
+		fmt.Fprintf(&b, ", script %s", linker.Script.Name)
+	}
+	if linker.Executable != nil && linker.Executable.HasEntry() {
+		fmt.Fprintf(&b, ", entry 0x%04x", linker.Executable.Header.Entry)
+	}
+	fmt.Fprintf(&b, "\n")
+
+	fmt.Fprintf(&b, "\nOBJECTS\n\n")
+	fmt.Fprintf(&b, "%3s  %s\n", "IDX", "NAME")
+	for objIdx := range linker.Objects {
+		fmt.Fprintf(&b, "%3d  %s\n", objIdx, linker.mapObjectName(objIdx))
+	}
+
+	fmt.Fprintf(&b, "\nSECTIONS\n\n")
+	fmt.Fprintf(&b, "%-10s  %-20s  %6s  %6s  %5s\n", "SECTION", "OBJECT", "REL", "ABS", "SIZE")
+	for _, info := range linker.SectionLayout {
+		fmt.Fprintf(&b, "%-10s  %-20s  0x%04x  0x%04x  %5d\n", info.Name, linker.mapObjectName(info.ObjectIndex),
+			info.RelAddress, info.AbsAddress, info.Size/2)
+	}
+	if linker.Executable != nil {
+		fmt.Fprintf(&b, "\n%-10s  %-20s  %6s  %6s  %5s\n", "OUTPUT", "", "", "ADDR", "SIZE")
+		for _, section := range linker.Executable.Sections {
+			fmt.Fprintf(&b, "%-10s  %-20s  %6s  0x%04x  %5d\n", section.Name, "", "",
+				section.Header.Address, section.Header.Size/2)
+		}
+	}
+
+	fmt.Fprintf(&b, "\nGLOBAL SYMBOLS\n\n")
+	fmt.Fprintf(&b, "%-20s  %6s  %-10s  %s\n", "NAME", "VALUE", "SECTION", "OBJECT")
+	var globals []*LinkedSymbol
+	for _, sym := range linker.Symbols {
+		if linker.SymbolMap[sym.Name] == sym {
+			globals = append(globals, sym)
+		}
+	}
+	slices.SortStableFunc(globals, func(a, b *LinkedSymbol) int {
+		return int(linker.symbolAddress(a)) - int(linker.symbolAddress(b))
+	})
+	for _, sym := range globals {
+		section := sym.Section
+		if section == "" {
+			section = "*ABS*"
+		}
+		fmt.Fprintf(&b, "%-20s  0x%04x  %-10s  %s\n", sym.Name, linker.symbolAddress(sym), section,
+			linker.mapObjectName(sym.ObjectIndex))
+	}
+
+	fmt.Fprintf(&b, "\nRELOCATIONS\n\n")
+	fmt.Fprintf(&b, "%6s  %-10s  %-20s  %-20s  %6s\n", "ADDR", "SECTION", "OBJECT", "SYMBOL", "VALUE")
+	applied := slices.Clone(linker.Applied)
+	slices.SortStableFunc(applied, func(a, b AppliedRelocation) int {
+		return int(a.Address) - int(b.Address)
+	})
+	for _, reloc := range applied {
+		fmt.Fprintf(&b, "0x%04x  %-10s  %-20s  %-20s  0x%04x\n", reloc.Address, reloc.Section,
+			linker.mapObjectName(reloc.ObjectIndex), reloc.Symbol, reloc.Value)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (linker *Linker) mapObjectName(objIdx int) string {
+	if objIdx < 0 && linker.Script != nil {
+		return linker.Script.Name
+	}
+	return linker.objectName(objIdx)
+}
+
+func SaveMapFile(linker *Linker, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return linker.WriteMap(file)
+}
@@ -0,0 +1,95 @@
+package linker_test
+
+import (
+	"dubcc/linker"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteMap(t *testing.T) {
+	// crossModule: m0 .text is 5 words and .data 1, m1 .text 3; the call
+	// and the branch are relative, so they keep their value in every mode
+	tests := []struct {
+		name   string
+		linker *linker.Linker
+		want   []string // rows that must show up, in this order
+	}{
+		{"relocator", linker.MakeRelocatorLinker(), []string{
+			"mode relocator, load address 0x0000, entry 0x0000",
+			"  0  m0.asm",
+			"  1  m1.asm",
+			".text       m0.asm                0x0000  0x0000      5",
+			".text       m1.asm                0x0005  0x0005      3",
+			".data       m0.asm                0x0008  0x0008      1",
+			".text                                     0x0000      8",
+			".data                                     0x0008      1",
+			"g                     0x0000  .text       m0.asm",
+			"f                     0x0006  .text       m1.asm",
+			"0x0001  .text       m0.asm                f                     0x0006",
+			"0x0003  .text       m0.asm                f                     0x0003",
+			"0x0007  .text       m1.asm                g                     0xfffa",
+		}},
+		{"absolute", linker.MakeAbsoluteLinker(0x40), []string{
+			"mode absolute, load address 0x0040, entry 0x0040",
+			"  0  m0.asm",
+			"  1  m1.asm",
+			".text       m0.asm                0x0000  0x0040      5",
+			".text       m1.asm                0x0005  0x0045      3",
+			".data       m0.asm                0x0008  0x0048      1",
+			".text                                     0x0040      8",
+			".data                                     0x0048      1",
+			"g                     0x0040  .text       m0.asm",
+			"f                     0x0046  .text       m1.asm",
+			"0x0041  .text       m0.asm                f                     0x0046",
+			"0x0043  .text       m0.asm                f                     0x0003",
+			"0x0047  .text       m1.asm                g                     0xfffa",
+		}},
+		{"script", linker.MakeScriptLinker(parseScript(t, "memory code 0x10 0x20\nmemory ram 0x80 0x10\n"+
+			"place .text code\nplace .* ram\nsymbol top = ram.end")), []string{
+			"mode absolute, load address 0x0000, script test.lds, entry 0x0000",
+			"  0  m0.asm",
+			"  1  m1.asm",
+			".text       m0.asm                0x0010  0x0010      5",
+			".text       m1.asm                0x0015  0x0015      3",
+			".data       m0.asm                0x0080  0x0080      1",
+			".text                                     0x0010      8",
+			".data                                     0x0080      1",
+			"g                     0x0010  .text       m0.asm",
+			"f                     0x0016  .text       m1.asm",
+			"top                   0x0090  *ABS*       test.lds",
+			"0x0011  .text       m0.asm                f                     0x0016",
+			"0x0013  .text       m0.asm                f                     0x0003",
+			"0x0017  .text       m1.asm                g                     0xfffa",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			link(t, test.linker, crossModule...)
+			var b strings.Builder
+			if err := test.linker.WriteMap(&b); err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(b.String(), "\n")
+			next := 0
+			for _, want := range test.want {
+				for next < len(lines) && lines[next] != want {
+					next++
+				}
+				if next == len(lines) {
+					t.Fatalf("no row %q after the previous ones in\n%s", want, b.String())
+				}
+			}
+
+			filename := filepath.Join(t.TempDir(), "out.map")
+			if err := linker.SaveMapFile(test.linker, filename); err != nil {
+				t.Fatal(err)
+			}
+			if saved, err := os.ReadFile(filename); err != nil || string(saved) != b.String() {
+				t.Errorf("saved map differs from the written one: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package linker
+
+import (
+	"bufio"
+	"dubcc"
+	"dubcc/assembler"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Linker script: where each section goes in memory. One directive per line,
+// ';' starts a comment:
+//
+//	memory code  0x0000 0x0200          ; region name, origin, length in words
+//	memory data  0x0200 0x0100
+//	memory stack 0x0300 0x0100
+//	place .text code                    ; sections matching the pattern go to the region
+//	place .data data align 4            ; starting at a multiple of 4
+//	place .bss* data
+//	symbol __stack_top = stack.end      ; number or region/section start, end or size
+//	symbol __data_size = .data.size
+//	symbol __heap = .bss.end + 1
+type Script struct {
+	Name       string
+	Regions    []MemoryRegion
+	Placements []Placement
+	Symbols    []ScriptSymbol
+}
+
+type MemoryRegion struct {
+	Name   string
+	Origin MachineAddress
+	Length MachineAddress // in words
+}
+
+type Placement struct {
+	Pattern string // path.Match pattern on the section name
+	Region  string
+	Align   MachineAddress
+	Line    int
+}
+
+// Symbol defined by the script, worth Ref's address plus Offset
+type ScriptSymbol struct {
+	Name   string
+	Ref    string // "region.attr" or ".section.attr", "" for a plain number
+	Offset int64
+	Line   int
+}
+
+func (region MemoryRegion) End() MachineAddress {
+	return region.Origin + region.Length
+}
+
+func (script *Script) Region(name string) (MemoryRegion, bool) {
+	for _, region := range script.Regions {
+		if region.Name == name {
+			return region, true
+		}
+	}
+	return MemoryRegion{}, false
+}
+
+// First placement whose pattern matches the section
+func (script *Script) Placement(section string) (Placement, bool) {
+	for _, placement := range script.Placements {
+		if matched, _ := path.Match(placement.Pattern, section); matched {
+			return placement, true
+		}
+	}
+	return Placement{}, false
+}
+
+func ParseScript(name string, src io.Reader) (*Script, error) {
+	script := &Script{Name: name}
+	var diags dubcc.Diagnostics
+
+	scanner := bufio.NewScanner(src)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		text, _, _ := strings.Cut(scanner.Text(), ";")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		errorf := func(format string, args ...any) {
+			diags.Errorf(name, lineNo, dubcc.ColumnOf(scanner.Text(), fields[0]), format, args...)
+		}
+
+		switch fields[0] {
+		case "memory":
+			if len(fields) != 4 {
+				errorf("usage: memory <name> <origin> <length>")
+				continue
+			}
+			origin, err1 := assembler.ParseNum(fields[2])
+			length, err2 := assembler.ParseNum(fields[3])
+			if err1 != nil || err2 != nil {
+				errorf("bad number in region %s", fields[1])
+				continue
+			}
+			region := MemoryRegion{Name: fields[1], Origin: origin, Length: length}
+			if _, exists := script.Region(region.Name); exists {
+				errorf("region %s already declared", region.Name)
+				continue
+			}
+			for _, other := range script.Regions {
+				if region.Origin < other.End() && other.Origin < region.End() {
+					errorf("region %s overlaps region %s", region.Name, other.Name)
+				}
+			}
+			script.Regions = append(script.Regions, region)
+
+		case "place":
+			if len(fields) != 3 && !(len(fields) == 5 && fields[3] == "align") {
+				errorf("usage: place <section> <region> [align <n>]")
+				continue
+			}
+			placement := Placement{Pattern: fields[1], Region: fields[2], Align: 1, Line: lineNo}
+			if _, err := path.Match(placement.Pattern, ""); err != nil {
+				errorf("bad section pattern %s", placement.Pattern)
+				continue
+			}
+			if _, exists := script.Region(placement.Region); !exists {
+				errorf("region %s is not declared", placement.Region)
+				continue
+			}
+			if len(fields) == 5 {
+				align, err := assembler.ParseNum(fields[4])
+				if err != nil || align == 0 {
+					errorf("alignment must be a positive number")
+					continue
+				}
+				placement.Align = align
+			}
+			script.Placements = append(script.Placements, placement)
+
+		case "symbol":
+			symbol, err := parseScriptSymbol(fields[1:])
+			if err != nil {
+				errorf("%v", err)
+				continue
+			}
+			symbol.Line = lineNo
+			script.Symbols = append(script.Symbols, symbol)
+
+		default:
+			errorf("unknown directive %s", fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := diags.Err(); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+// <name> = <number> | <ref> [(+|-) <number>]
+func parseScriptSymbol(fields []string) (symbol ScriptSymbol, err error) {
+	if len(fields) < 3 || fields[1] != "=" {
+		return symbol, fmt.Errorf("usage: symbol <name> = <value>")
+	}
+	symbol.Name = fields[0]
+	expr := fields[2:]
+
+	if num, err := assembler.ParseNum(expr[0]); err == nil {
+		symbol.Offset = int64(num)
+	} else {
+		dot := strings.LastIndex(expr[0], ".")
+		switch attr := expr[0][dot+1:]; {
+		case dot <= 0:
+			return symbol, fmt.Errorf("%s is neither a number nor <region>.<start|end|size>", expr[0])
+		case attr != "start" && attr != "end" && attr != "size":
+			return symbol, fmt.Errorf("unknown attribute %s, use start, end or size", attr)
+		}
+		symbol.Ref = expr[0]
+	}
+
+	switch {
+	case len(expr) == 1:
+	case len(expr) == 3 && (expr[1] == "+" || expr[1] == "-"):
+		num, err := assembler.ParseNum(expr[2])
+		if err != nil {
+			return symbol, fmt.Errorf("bad number %s", expr[2])
+		}
+		if expr[1] == "-" {
+			symbol.Offset -= int64(num)
+		} else {
+			symbol.Offset += int64(num)
+		}
+	default:
+		return symbol, fmt.Errorf("symbol values are <value> [(+|-) <number>]")
+	}
+	return symbol, nil
+}
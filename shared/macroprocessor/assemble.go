@@ -0,0 +1,24 @@
+package macroprocessor
+
+import (
+	"dubcc"
+	"dubcc/assembler"
+)
+
+// Expands the macros of a source file and runs both assembler passes over
+// it, like dubasm. diags holds the problems of both stages, and err is
+// non-nil if any of them is an error. info is returned even then, so a
+// listing can still be written.
+func AssembleSource(name, source string) (info *assembler.Info, diags dubcc.Diagnostics, err error) {
+	mp := MakeMacroProcessor(0)
+	expanded, diags := mp.ExpandSource(name, source)
+
+	asm := assembler.MakeAssembler()
+	asm.SetFile(name)
+	for _, line := range expanded {
+		asm.FirstPassSource(line)
+	}
+	asm.SecondPass()
+	diags = append(diags, asm.Diagnostics()...)
+	return &asm, diags, diags.Err()
+}
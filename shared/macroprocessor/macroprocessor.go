@@ -2,7 +2,7 @@ package macroprocessor
 
 import (
 	"dubcc"
-	_ "dubcc/assembler"
+	"dubcc/assembler"
 	"errors"
 	"fmt"
 	"log"
@@ -23,6 +23,7 @@ type Info struct {
 	state        int
 	currentDef   *MacroMeta
 	lineCount    int
+	openedAt     dubcc.SourceLine // MACRO line of the definition being read
 }
 
 type Macro struct {
@@ -50,6 +51,9 @@ func (info *Info) ProcessLine(rawline string) (output []string, err error) {
 	line, err := dubcc.ParseAsmLine(rawline)
 	if err != nil { return nil, err }
 	if line.Op == "MACRO" {
+		if info.state == GND {
+			info.openedAt = dubcc.SourceLine{Line: info.lineCount, Text: rawline}
+		}
 		info.state++
 	}
 
@@ -81,7 +85,7 @@ func (info *Info) handleMacroDef(line dubcc.InLine) (err error) {
 	if info.currentDef == nil && info.state == BODY {
     fields := strings.Fields(line.Raw)
 		if len(fields) == 0 {
-			return errors.New("bad macro syntax!")
+			return errors.New("MACRO must be followed by the macro prototype")
 		}
 		info.currentDef = &MacroMeta{
 			name: fields[0],
@@ -139,7 +143,8 @@ func (info *Info) expandAndRunAllMacros(line dubcc.InLine) (out []string, err er
 func (info *Info) expandAndRunMacro(macro Macro, line dubcc.InLine) ([]string, error) {
 	macro_err_str := []string{"macro error!"}
 	if len(line.Args) != len(macro.args) {
-		return macro_err_str, errors.New("number of arguments doesn't match")
+		return macro_err_str, fmt.Errorf("macro %s expects %d arguments, got %d",
+			line.Op, len(macro.args), len(line.Args))
 	}
 
 	substitutions := make(map[string]string)
@@ -154,9 +159,14 @@ func (info *Info) expandAndRunMacro(macro Macro, line dubcc.InLine) ([]string, e
 	for _, raw := range macro.body {
 		words := strings.Split(raw, " ")
 		for i, word := range words {
-			wdata, wfound := substitutions[word]
+			// keep the indirect marker when substituting "ARG,I"
+			base, indirect := assembler.CutIndirect(word)
+			wdata, wfound := substitutions[base]
 			if wfound {
 				words[i] = wdata
+				if indirect {
+					words[i] += ",I"
+				}
 			}
 		}
 		expanded := strings.Join(words, " ")
@@ -167,6 +177,38 @@ func (info *Info) expandAndRunMacro(macro Macro, line dubcc.InLine) ([]string, e
 	return macro_expansion, nil
 }
 
+// Expands a whole source file. Every output line remembers the source
+// line (and macro, if any) it came from; problems are collected instead
+// of aborting the expansion.
+func (info *Info) ExpandSource(file string, text string) (out []dubcc.SourceLine, diags dubcc.Diagnostics) {
+	for _, raw := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		info.lineCount++
+		lines, err := info.ProcessLine(raw)
+		if err != nil {
+			parsed, _ := dubcc.ParseAsmLine(raw)
+			diags.Errorf(file, info.lineCount, dubcc.ColumnOf(raw, parsed.Op), "%v", err)
+			continue
+		}
+		macro := ""
+		if parsed, _ := dubcc.ParseAsmLine(raw); info.macros[parsed.Op] != nil {
+			macro = parsed.Op
+		}
+		for _, line := range lines {
+			out = append(out, dubcc.SourceLine{
+				File:  file,
+				Line:  info.lineCount,
+				Text:  line,
+				Macro: macro,
+			})
+		}
+	}
+	if info.state != GND || info.currentDef != nil {
+		at := info.openedAt
+		diags.Errorf(file, at.Line, dubcc.ColumnOf(at.Text, "MACRO"), "MACRO without matching MEND")
+	}
+	return out, diags
+}
+
 func (info *Info) MacroReport() string {
 	return fmt.Sprintf("Macro report:\n\t%d macros", len(info.macros))
 }
@@ -0,0 +1,52 @@
+package macroprocessor_test
+
+import (
+	"dubcc"
+	"dubcc/macroprocessor"
+	"io"
+	"log"
+	"slices"
+	"testing"
+)
+
+// Every bad call is reported with its position, and expansion goes on
+func TestExpandSourceErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+	source := `MACRO
+INC X
+add X
+MEND
+INC 1 2
+load 3
+INC
+x: INC 4 5
+INC 9
+ MACRO
+DOUBLE Y
+add Y
+`
+	mp := macroprocessor.MakeMacroProcessor(0)
+	out, diags := mp.ExpandSource("bad.asm", source)
+
+	var got []string
+	for _, diag := range diags {
+		got = append(got, diag.Error())
+	}
+	want := []string{
+		"bad.asm:5:1: error: macro INC expects 1 arguments, got 2",
+		"bad.asm:7:1: error: macro INC expects 1 arguments, got 0",
+		"bad.asm:8:4: error: macro INC expects 1 arguments, got 2",
+		"bad.asm:10:2: error: MACRO without matching MEND",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+
+	wantOut := []dubcc.SourceLine{
+		{File: "bad.asm", Line: 6, Text: "load 3"},
+		{File: "bad.asm", Line: 9, Text: "add 9", Macro: "INC"},
+	}
+	if !slices.Equal(out, wantOut) {
+		t.Errorf("expanded %+v\nwant %+v", out, wantOut)
+	}
+}
@@ -0,0 +1,207 @@
+package dubcc
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Memory images as text, to hand patch a program or pass it to other
+// tools. They hold absolute addresses only: no symbols, no fixups.
+type ImageFormat int
+
+const (
+	// Intel HEX adapted to the machine: record addresses count words and
+	// each word is two bytes, big-endian. Data (00), end of file (01) and
+	// start address (05, the entry point) records are used.
+	ImageHex ImageFormat = iota + 1
+	// One line per row of words, all numbers in hex:
+	//
+	//	entry 0000
+	//	0000: 0203 0003 000b
+	//
+	// ';' starts a comment.
+	ImageWords
+)
+
+var ImageFormatErr = errors.New("bad memory image")
+
+const imageWordsPerLine = 8
+
+// The format a file name asks for: .hex or .mem
+func ImageFormatOf(filename string) (ImageFormat, bool) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".hex":
+		return ImageHex, true
+	case ".mem":
+		return ImageWords, true
+	}
+	return 0, false
+}
+
+func WriteImage(w io.Writer, img Image, format ImageFormat) error {
+	for _, seg := range img.Segments {
+		if seg.End() > 0x10000 {
+			return fmt.Errorf("segment %s [0x%04x, 0x%04x) is past 16 bit addresses", seg.Name, seg.Address, seg.End())
+		}
+	}
+	var b strings.Builder
+	switch format {
+	case ImageHex:
+		for _, seg := range img.Segments {
+			for row := 0; row < len(seg.Data); row += imageWordsPerLine {
+				words := seg.Data[row:min(row+imageWordsPerLine, len(seg.Data))]
+				data := make([]byte, 0, 2*len(words))
+				for _, word := range words {
+					data = append(data, byte(word>>8), byte(word))
+				}
+				writeHexRecord(&b, seg.Address+MachineAddress(row), 0x00, data)
+			}
+		}
+		entry := uint32(img.Entry)
+		writeHexRecord(&b, 0, 0x05, []byte{byte(entry >> 24), byte(entry >> 16), byte(entry >> 8), byte(entry)})
+		writeHexRecord(&b, 0, 0x01, nil)
+	case ImageWords:
+		fmt.Fprintf(&b, "entry %04x\n", img.Entry)
+		for _, seg := range img.Segments {
+			fmt.Fprintf(&b, "; %s\n", seg.Name)
+			for row := 0; row < len(seg.Data); row += imageWordsPerLine {
+				fmt.Fprintf(&b, "%04x:", seg.Address+MachineAddress(row))
+				for _, word := range seg.Data[row:min(row+imageWordsPerLine, len(seg.Data))] {
+					fmt.Fprintf(&b, " %04x", word)
+				}
+				fmt.Fprintf(&b, "\n")
+			}
+		}
+	default:
+		return fmt.Errorf("unknown image format %d", format)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ":LLAAAATT<data>CC", CC making all the bytes add up to zero
+func writeHexRecord(b *strings.Builder, addr MachineAddress, kind byte, data []byte) {
+	record := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), kind}, data...)
+	sum := byte(0)
+	for _, v := range record {
+		sum += v
+	}
+	record = append(record, -sum)
+	fmt.Fprintf(b, ":%s\n", strings.ToUpper(hex.EncodeToString(record)))
+}
+
+// Reads an image. Consecutive rows become one segment; without an entry
+// point, execution starts at the lowest address.
+func ReadImage(r io.Reader, format ImageFormat) (Image, error) {
+	var img Image
+	hasEntry, ended := false, false
+	add := func(addr MachineAddress, words []MachineWord) {
+		if n := len(img.Segments); n > 0 && img.Segments[n-1].End() == addr {
+			img.Segments[n-1].Data = append(img.Segments[n-1].Data, words...)
+			return
+		}
+		img.Segments = append(img.Segments, Segment{Name: fmt.Sprintf("0x%04x", addr), Address: addr, Data: words})
+	}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), ";")
+		text = strings.TrimSpace(text)
+		if text == "" || ended {
+			continue
+		}
+		errorf := func(format string, args ...any) error {
+			return fmt.Errorf("%w: line %d: %s", ImageFormatErr, line, fmt.Sprintf(format, args...))
+		}
+
+		switch format {
+		case ImageHex:
+			hexText, found := strings.CutPrefix(text, ":")
+			record, err := hex.DecodeString(hexText)
+			if !found || err != nil || len(record) < 5 || int(record[0]) != len(record)-5 {
+				return img, errorf("not a HEX record")
+			}
+			sum := byte(0)
+			for _, v := range record {
+				sum += v
+			}
+			if sum != 0 {
+				return img, errorf("checksum 0x%02X should be 0x%02X", record[len(record)-1], record[len(record)-1]-sum)
+			}
+			addr, kind, data := MachineAddress(record[1])<<8|MachineAddress(record[2]), record[3], record[4:len(record)-1]
+			switch {
+			case kind == 0x00 && len(data)%2 == 0:
+				words := make([]MachineWord, len(data)/2)
+				for i := range words {
+					words[i] = MachineWord(data[2*i])<<8 | MachineWord(data[2*i+1])
+				}
+				add(addr, words)
+			case kind == 0x00:
+				return img, errorf("data record with %d bytes, words take 2", len(data))
+			case kind == 0x01:
+				ended = true
+			case kind == 0x05 && len(data) == 4:
+				img.Entry = MachineAddress(data[0])<<24 | MachineAddress(data[1])<<16 | MachineAddress(data[2])<<8 | MachineAddress(data[3])
+				hasEntry = true
+			default:
+				return img, errorf("unsupported record type %02X", kind)
+			}
+
+		case ImageWords:
+			if entry, found := strings.CutPrefix(text, "entry"); found {
+				addr, err := strconv.ParseUint(strings.TrimSpace(entry), 16, 16)
+				if err != nil {
+					return img, errorf("bad entry %q", strings.TrimSpace(entry))
+				}
+				img.Entry, hasEntry = addr, true
+				continue
+			}
+			addrText, wordsText, found := strings.Cut(text, ":")
+			addr, err := strconv.ParseUint(strings.TrimSpace(addrText), 16, 16)
+			if !found || err != nil {
+				return img, errorf("expected \"address: word ...\", got %q", text)
+			}
+			var words []MachineWord
+			for _, field := range strings.Fields(wordsText) {
+				word, err := strconv.ParseUint(field, 16, 16)
+				if err != nil {
+					return img, errorf("bad word %q", field)
+				}
+				words = append(words, MachineWord(word))
+			}
+			add(addr, words)
+
+		default:
+			return img, fmt.Errorf("unknown image format %d", format)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return img, err
+	}
+	if format == ImageHex && !ended {
+		return img, fmt.Errorf("%w: no end of file record", ImageFormatErr)
+	}
+
+	if !hasEntry && len(img.Segments) > 0 {
+		img.Entry = slices.MinFunc(img.Segments, func(a, b Segment) int { return int(a.Address) - int(b.Address) }).Address
+	}
+	return img, nil
+}
+
+func SaveImageFile(img Image, filename string, format ImageFormat) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return WriteImage(file, img, format)
+}
@@ -0,0 +1,103 @@
+package dubcc_test
+
+import (
+	"bytes"
+	"dubcc"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImageRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	for range 200 {
+		var img dubcc.Image
+		addr := dubcc.MachineAddress(rng.IntN(0x100))
+		for range 1 + rng.IntN(4) {
+			seg := dubcc.Segment{Name: fmt.Sprintf("0x%04x", addr), Address: addr}
+			for range 1 + rng.IntN(30) {
+				seg.Data = append(seg.Data, dubcc.MachineWord(rng.Uint32()))
+			}
+			img.Segments = append(img.Segments, seg)
+			addr = seg.End() + 1 + dubcc.MachineAddress(rng.IntN(0x100))
+		}
+		img.Entry = dubcc.MachineAddress(rng.IntN(int(addr)))
+
+		for _, format := range []dubcc.ImageFormat{dubcc.ImageHex, dubcc.ImageWords} {
+			var buf bytes.Buffer
+			if err := dubcc.WriteImage(&buf, img, format); err != nil {
+				t.Fatal(err)
+			}
+			got, err := dubcc.ReadImage(&buf, format)
+			if err != nil {
+				t.Fatalf("format %d: %v", format, err)
+			}
+			if !reflect.DeepEqual(got, img) {
+				t.Fatalf("format %d: got %+v\nwant %+v", format, got, img)
+			}
+		}
+	}
+}
+
+func TestReadImage(t *testing.T) {
+	// the example in the Intel HEX spec, read as words
+	hex := ":10010000214601360121470136007EFE09D2190140\n" +
+		":100110002146017E17C20001FF5F16002148011928\n" +
+		":00000001FF\n"
+	img, err := dubcc.ReadImage(strings.NewReader(hex), dubcc.ImageHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img.Segments) != 2 || img.Segments[0].Address != 0x100 || img.Segments[1].Address != 0x110 ||
+		img.Segments[0].Data[0] != 0x2146 || img.Entry != 0x100 {
+		t.Errorf("got %+v", img)
+	}
+
+	words := "; patched by hand\n  entry 0002\n0000: 0003 0000 ; load 0\n0002: 000b\n\n0010: ffff\n"
+	img, err = dubcc.ReadImage(strings.NewReader(words), dubcc.ImageWords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []dubcc.Segment{
+		{Name: "0x0000", Address: 0, Data: []dubcc.MachineWord{0x0003, 0x0000, 0x000b}},
+		{Name: "0x0010", Address: 0x10, Data: []dubcc.MachineWord{0xffff}},
+	}
+	if !reflect.DeepEqual(img.Segments, want) || img.Entry != 2 {
+		t.Errorf("got %+v", img)
+	}
+}
+
+func TestReadImageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format dubcc.ImageFormat
+		text   string
+	}{
+		{"checksum", dubcc.ImageHex, ":0400000000030000F8\n:00000001FF\n"},
+		{"odd bytes", dubcc.ImageHex, ":03000000000300FA\n:00000001FF\n"},
+		{"length", dubcc.ImageHex, ":0500000000030000F9\n:00000001FF\n"},
+		{"no colon", dubcc.ImageHex, "0400000000030000F9\n:00000001FF\n"},
+		{"record type", dubcc.ImageHex, ":020000040000FA\n:00000001FF\n"},
+		{"no end", dubcc.ImageHex, ":0400000000030000F9\n"},
+		{"no address", dubcc.ImageWords, "0003 0000\n"},
+		{"bad word", dubcc.ImageWords, "0000: 0003 10000\n"},
+		{"bad entry", dubcc.ImageWords, "entry start\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := dubcc.ReadImage(strings.NewReader(test.text), test.format); !errors.Is(err, dubcc.ImageFormatErr) {
+				t.Fatalf("want %v, got %v", dubcc.ImageFormatErr, err)
+			}
+		})
+	}
+}
+
+func TestWriteImageTooHigh(t *testing.T) {
+	img := dubcc.Image{Segments: []dubcc.Segment{{Name: ".text", Address: 0xffff, Data: make([]dubcc.MachineWord, 2)}}}
+	if err := dubcc.WriteImage(&bytes.Buffer{}, img, dubcc.ImageHex); err == nil {
+		t.Fatal("segment past 0xffff was written")
+	}
+}
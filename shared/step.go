@@ -0,0 +1,125 @@
+package dubcc
+
+import (
+	"context"
+	"fmt"
+)
+
+type StepKind byte
+
+const (
+	StepExecuted  StepKind = iota // instruction ran, machine can continue
+	StepHalted                    // machine is (or just got) halted
+	StepIOBlocked                 // read found no input, PC is kept on the instruction
+	StepFault                     // instruction raised a Fault, see Sim.Fault
+)
+
+func (k StepKind) String() string {
+	switch k {
+	case StepExecuted:
+		return "executed"
+	case StepHalted:
+		return "halted"
+	case StepIOBlocked:
+		return "io-blocked"
+	case StepFault:
+		return "fault"
+	default:
+		return fmt.Sprintf("StepKind(%d)", byte(k))
+	}
+}
+
+// Resultado de um ciclo de busca/decodificação/execução
+type StepResult struct {
+	Kind StepKind
+	PC   MachineWord   // address of the instruction
+	Inst Instruction   // decoded instruction (zero value if decoding failed)
+	Args []MachineWord // opword followed by the raw arguments
+	Err  error         // the *Fault when Kind == StepFault
+}
+
+// Executes a single instruction at PC.
+// PC is advanced before the handler runs so branches can override it.
+func (s *Sim) Step() StepResult {
+	pc := s.GetRegister(RegPC)
+	switch s.State {
+	case SimStateHalt:
+		return StepResult{Kind: StepHalted, PC: pc}
+	case SimStateFault:
+		return StepResult{Kind: StepFault, PC: pc, Err: s.Fault}
+	}
+	// a blocked read gets retried, everything else keeps its mode
+	if s.State == SimStateIOBlocked {
+		s.State = SimStateRun
+	}
+
+	if int(pc) >= len(s.Mem.Work) {
+		s.Raise(FaultMemoryBounds, pc)
+		return s.faultResult(StepResult{PC: pc}, 0)
+	}
+	instWord := s.Mem.Work[pc]
+	s.SetRegister(RegRI, instWord)
+	inst, ifound := s.InstructionFromWord(instWord)
+	handler, hfound := s.Handlers[inst.Repr]
+	if !ifound || !hfound {
+		s.Raise(FaultIllegalOpcode, instWord)
+		return s.faultResult(StepResult{PC: pc}, instWord)
+	}
+
+	argsTerm := int(pc) + 1 + inst.NumArgs
+	if argsTerm > len(s.Mem.Work) {
+		s.Raise(FaultMemoryBounds, MachineWord(argsTerm-1))
+		return s.faultResult(StepResult{PC: pc, Inst: inst}, instWord)
+	}
+	args := s.Mem.Work[pc:argsTerm]
+	result := StepResult{Kind: StepExecuted, PC: pc, Inst: inst, Args: args}
+
+	s.SetRegister(RegPC, MachineWord(argsTerm))
+	handler(s, args)
+
+	if s.State != SimStateIOBlocked {
+		s.Steps++
+	}
+	switch s.State {
+	case SimStateIOBlocked:
+		s.SetRegister(RegPC, pc) // actually block
+		result.Kind = StepIOBlocked
+	case SimStateHalt:
+		result.Kind = StepHalted
+	case SimStateFault:
+		// leave PC on the culprit so it shows up in the GUI
+		s.SetRegister(RegPC, pc)
+		return s.faultResult(result, instWord)
+	}
+	return result
+}
+
+// Completes the fault raised while executing the instruction at result.PC.
+func (s *Sim) faultResult(result StepResult, word MachineWord) StepResult {
+	s.Fault.PC = result.PC
+	s.Fault.Word = word
+	result.Kind = StepFault
+	result.Err = s.Fault
+	return result
+}
+
+// Steps the machine until it halts, blocks on input, faults,
+// ctx is done or maxSteps instructions ran (0 means no limit).
+// The returned error is nil only when the machine halted or blocked;
+// a blocked read returns so the caller can supply input and call Run again.
+func (s *Sim) Run(ctx context.Context, maxSteps uint64) (StepResult, error) {
+	var result StepResult
+	for steps := uint64(0); maxSteps == 0 || steps < maxSteps; steps++ {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		result = s.Step()
+		switch result.Kind {
+		case StepHalted, StepIOBlocked:
+			return result, nil
+		case StepFault:
+			return result, result.Err
+		}
+	}
+	return result, StepLimitErr
+}
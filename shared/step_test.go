@@ -0,0 +1,95 @@
+package dubcc_test
+
+import (
+	"context"
+	"dubcc"
+	"errors"
+	"testing"
+)
+
+// A machine ready to run source from address 0
+func loadSim(t *testing.T, source string) *dubcc.Sim {
+	t.Helper()
+	words, _ := assemble(t, "test.asm", source)
+	sim := dubcc.MakeSim(0x100)
+	if err := sim.LoadImage(dubcc.Image{Segments: []dubcc.Segment{{Name: "test", Data: words}}}); err != nil {
+		t.Fatal(err)
+	}
+	sim.State = dubcc.SimStateRun
+	return &sim
+}
+
+func TestStep(t *testing.T) {
+	sim := loadSim(t, "load 3\nadd 4\nstop\nend")
+	for _, want := range []struct {
+		kind dubcc.StepKind
+		pc   dubcc.MachineWord
+		name string
+	}{
+		{dubcc.StepExecuted, 0, "load"},
+		{dubcc.StepExecuted, 2, "add"},
+		{dubcc.StepHalted, 4, "stop"},
+	} {
+		result := sim.Step()
+		if result.Kind != want.kind || result.PC != want.pc || result.Inst.Name != want.name {
+			t.Fatalf("got %v %s at 0x%04x, want %v %s at 0x%04x",
+				result.Kind, result.Inst.Name, result.PC, want.kind, want.name, want.pc)
+		}
+	}
+	if acc := sim.GetRegister(dubcc.RegACC); acc != 7 {
+		t.Errorf("ACC = %d, want 7", acc)
+	}
+	// a halted machine stays halted
+	if result := sim.Step(); result.Kind != dubcc.StepHalted || sim.Steps != 3 {
+		t.Errorf("after halting: %v, %d steps", result.Kind, sim.Steps)
+	}
+}
+
+func TestRunHalt(t *testing.T) {
+	sim := loadSim(t, "load 3\nstop\nend")
+	result, err := sim.Run(context.Background(), 0)
+	if err != nil || result.Kind != dubcc.StepHalted || result.PC != 2 {
+		t.Fatalf("got %v at 0x%04x, %v", result.Kind, result.PC, err)
+	}
+}
+
+func TestRunStepLimit(t *testing.T) {
+	sim := loadSim(t, "l: br l\nend")
+	result, err := sim.Run(context.Background(), 10)
+	if !errors.Is(err, dubcc.StepLimitErr) || result.Kind != dubcc.StepExecuted || sim.Steps != 10 {
+		t.Fatalf("got %v, %v after %d steps", result.Kind, err, sim.Steps)
+	}
+	// and it goes on from there
+	if _, err := sim.Run(context.Background(), 5); !errors.Is(err, dubcc.StepLimitErr) || sim.Steps != 15 {
+		t.Fatalf("got %v after %d steps", err, sim.Steps)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	sim := loadSim(t, "l: br l\nend")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sim.Run(ctx, 0); !errors.Is(err, context.Canceled) || sim.Steps != 0 {
+		t.Fatalf("got %v after %d steps", err, sim.Steps)
+	}
+}
+
+func TestRunIOBlocked(t *testing.T) {
+	sim := loadSim(t, "load 1\nread ACC\nwrite ACC\nstop\nend")
+	result, err := sim.Run(context.Background(), 0)
+	if err != nil || result.Kind != dubcc.StepIOBlocked || result.PC != 2 || sim.Steps != 1 {
+		t.Fatalf("got %v at 0x%04x, %v after %d steps", result.Kind, result.PC, err, sim.Steps)
+	}
+	if pc := sim.GetRegister(dubcc.RegPC); pc != 2 {
+		t.Errorf("PC = 0x%04x, want it kept on the read", pc)
+	}
+
+	sim.TxInWord('x')
+	result, err = sim.Run(context.Background(), 0)
+	if err != nil || result.Kind != dubcc.StepHalted {
+		t.Fatalf("got %v, %v", result.Kind, err)
+	}
+	if out := sim.RxOutWord(); out != 'x' {
+		t.Errorf("wrote %q, want 'x'", out)
+	}
+}
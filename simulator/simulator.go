@@ -1,80 +1,269 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"dubcc"
+	"dubcc/assembler"
+	"errors"
 	"fmt"
 	"io"
-	"bufio"
 	"log"
 	"os"
 	"os/signal"
-	"time"
-	"dubcc"
-	"github.com/k0kubun/pp/v3"
+	"strconv"
+	"strings"
+)
+
+const usage = `usage: dubsim [options] <program.hpx|program.o|program.hex|program.mem>
+
+Loads a DULF executable produced by the linker (or a single object, or a
+memory image written by the linker with --hex or --mem) and runs it. read takes one character from stdin, write prints one to stdout.
+
+options:
+  -m, --mem <words>     memory size in words (default 1024)
+  -L, --limit <n>       stop after n instructions (default: no limit)
+  -e, --entry <addr>    override the entry point
+  -b, --base <addr>     load a relocatable program at addr
+  -n, --numeric         read/write decimal numbers, one per line
+  --raw                 program is a raw big-endian word stream
+  -d, --dump            print the registers when the machine stops
+  -v, --verbose         log every executed instruction
+
+exit status:
+  0 halted   1 load/usage error   2 fault   3 instruction limit
+  4 read with no more input   130 interrupted
+`
+
+const (
+	exitHalted = iota
+	exitError
+	exitFault
+	exitLimit
+	exitNoInput
+	exitInterrupted = 130
 )
 
+type options struct {
+	memSize dubcc.MachineAddress
+	limit   uint64
+	entry   *dubcc.MachineAddress
+	base    dubcc.MachineAddress
+	numeric bool
+	raw     bool
+	dump    bool
+	verbose bool
+	program string
+}
+
 func main() {
-	memCap := dubcc.MachineAddress(1<<5)
-	sim := dubcc.MakeSim(memCap) // 32b for now
-	log.Printf("loaded %d", memCap)
-	reader := bufio.NewReader(os.Stdin)
-
-	{ // install interrupt handler
-		c := make (chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		go func() {
-			for _ = range c {
-				sim.State = dubcc.SimStateHalt
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dubsim: %v\n%s", err, usage)
+		os.Exit(exitError)
+	}
+	if !opts.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	sim := dubcc.MakeSim(opts.memSize)
+	if err := load(&sim, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "dubsim: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	status := run(ctx, &sim, opts)
+	if opts.dump {
+		dumpRegisters(&sim)
+	}
+	os.Exit(status)
+}
+
+func parseArgs(args []string) (opts options, err error) {
+	opts.memSize = 1 << 10
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := func() (dubcc.MachineAddress, error) {
+			if i+1 == len(args) {
+				return 0, fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			return assembler.ParseNum(args[i])
+		}
+		switch arg {
+		case "-m", "--mem":
+			if opts.memSize, err = value(); err != nil {
+				return opts, err
+			}
+			if opts.memSize == 0 || opts.memSize > 1<<16 {
+				return opts, fmt.Errorf("memory size must be between 1 and %d words", 1<<16)
 			}
-		}()
-	}
-
-	{ // read bin to memory
-		buf := make([]byte, 2) // read one words worth at a time
-		read_file: for mempos := range sim.Mem.Work {
-			for idx := range buf {
-				readb, err := reader.ReadByte()
-				if err != nil {
-					if err == io.EOF {
-						break read_file
-					}
-					log.Fatal("error reading stdin: %v", err)
-				}
-				buf[idx] = readb
+		case "-L", "--limit":
+			if opts.limit, err = value(); err != nil {
+				return opts, err
 			}
-			v := dubcc.MachineWord(buf[0] << 8 + buf[1])
-			fmt.Fprintf(os.Stderr, "got word %x (%d) out of %v\n", v, v, buf)
-			sim.Mem.Work[mempos] = v
+		case "-e", "--entry":
+			entry, err := value()
+			if err != nil {
+				return opts, err
+			}
+			opts.entry = &entry
+		case "-b", "--base":
+			if opts.base, err = value(); err != nil {
+				return opts, err
+			}
+		case "-n", "--numeric":
+			opts.numeric = true
+		case "--raw":
+			opts.raw = true
+		case "-d", "--dump":
+			opts.dump = true
+		case "-v", "--verbose":
+			opts.verbose = true
+		case "-h", "--help":
+			fmt.Print(usage)
+			os.Exit(exitHalted)
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return opts, fmt.Errorf("unknown option %s", arg)
+			}
+			if opts.program != "" {
+				return opts, fmt.Errorf("only one program can be run")
+			}
+			opts.program = arg
 		}
 	}
+	if opts.program == "" {
+		return opts, errors.New("no program given")
+	}
+	return opts, nil
+}
 
+func load(sim *dubcc.Sim, opts options) error {
+	data, err := os.ReadFile(opts.program)
+	if err != nil {
+		return err
+	}
+
+	var img dubcc.Image
+	if opts.raw {
+		if len(data)%2 != 0 {
+			return fmt.Errorf("%s: raw program has an odd number of bytes", opts.program)
+		}
+		words := make([]dubcc.MachineWord, len(data)/2)
+		for i := range words {
+			words[i] = dubcc.MachineWord(data[2*i])<<8 | dubcc.MachineWord(data[2*i+1])
+		}
+		img.Segments = []dubcc.Segment{{Name: "raw", Data: words}}
+	} else if format, ok := dubcc.ImageFormatOf(opts.program); ok {
+		img, err = dubcc.ReadImage(bytes.NewReader(data), format)
+		if err != nil {
+			return fmt.Errorf("%s: %v", opts.program, err)
+		}
+	} else {
+		obj, err := assembler.Read(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %v", opts.program, err)
+		}
+		img = obj.Image()
+	}
+	if opts.entry != nil {
+		img.Entry = *opts.entry
+	}
+
+	if err := sim.LoadImageAt(img, opts.base); err != nil {
+		return fmt.Errorf("%s: %v", opts.program, err)
+	}
+	return nil
+}
+
+// Runs until the machine stops for good, feeding it stdin on every
+// blocked read. Returns the exit status.
+func run(ctx context.Context, sim *dubcc.Sim, opts options) int {
+	in := bufio.NewReader(os.Stdin)
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	// output is written between batches, -v logs every instruction
+	batch := uint64(1 << 12)
+	if opts.verbose {
+		batch = 1
+	}
 	sim.State = dubcc.SimStateRun
 	for {
-		if sim.State != dubcc.SimStateRun {
-			break
+		if opts.limit > 0 && sim.Steps >= opts.limit {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "dubsim: %v after %d instructions\n", dubcc.StepLimitErr, sim.Steps)
+			return exitLimit
+		}
+		steps := batch
+		if opts.limit > 0 {
+			steps = min(steps, opts.limit-sim.Steps)
+		}
+
+		result, err := sim.Run(ctx, steps)
+		if opts.verbose && ctx.Err() == nil && result.Kind != dubcc.StepIOBlocked {
+			log.Printf("0x%04x: %s %v", result.PC, result.Inst.Name, result.Args)
 		}
-		pc := sim.Isa.Registers["PC"]
-		ri := sim.Isa.Registers["RI"]
-		//re := sim.Isa.Registers["RE"]
-		ri.Content = sim.Mem.Work[pc.Content]
-		inst, ifound := sim.Isa.InstructionFromWord(ri.Content)
-		if !ifound {
-			fmt.Fprintf(os.Stderr,
-				"invalid instruction %x (%d)\n", ri.Content, ri.Content,
-			)
+		for len(sim.OutWords) > 0 {
+			writeWord(out, sim.RxOutWord(), opts.numeric)
 		}
-		handler, hfound := sim.Handlers[inst.Repr]
-		if !hfound {
-			fmt.Fprintf(os.Stderr, "couldn't handle instruction %v\n", inst)
+
+		switch {
+		case ctx.Err() != nil:
+			out.Flush()
+			fmt.Fprintln(os.Stderr, "dubsim: interrupted")
+			return exitInterrupted
+		case result.Kind == dubcc.StepHalted:
+			return exitHalted
+		case result.Kind == dubcc.StepFault:
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "dubsim: %v\n", err)
+			return exitFault
+		case result.Kind == dubcc.StepIOBlocked:
+			out.Flush()
+			word, err := readWord(in, opts.numeric)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "dubsim: read at 0x%04x: %v\n", result.PC, err)
+				return exitNoInput
+			}
+			sim.TxInWord(word)
 		}
-		instPos := dubcc.MachineAddress(pc.Content)
-		argsTerm := instPos + 1 + dubcc.MachineAddress(inst.NumArgs)
-		// set pc before calling the handler
-		// that way branching works
-		pc.Content += dubcc.MachineWord(1 + inst.NumArgs)
-		args := sim.Mem.Work[instPos:argsTerm]
-		log.Printf("Executing %s with %v", inst.Name, args)
-		handler(&sim, args)
-		time.Sleep(100 * time.Millisecond)
-	}
-	pp.Printf("Simulation state: %v", sim)
+		// otherwise the batch ran out, go on
+	}
+}
+
+func readWord(in *bufio.Reader, numeric bool) (dubcc.MachineWord, error) {
+	if !numeric {
+		r, _, err := in.ReadRune()
+		return dubcc.MachineWord(r), err
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return 0, err
+	}
+	num, err := strconv.ParseInt(strings.TrimSpace(line), 0, 32)
+	return dubcc.MachineWord(num), err
+}
+
+func writeWord(out *bufio.Writer, word dubcc.MachineWord, numeric bool) {
+	if numeric {
+		fmt.Fprintln(out, int16(word))
+	} else {
+		out.WriteRune(rune(word))
+	}
+}
+
+func dumpRegisters(sim *dubcc.Sim) {
+	for _, name := range []string{"PC", "SP", "ACC", "MOP", "RI", "RE", "R0", "R1"} {
+		val := sim.GetRegisterByName(name)
+		fmt.Fprintf(os.Stderr, "%-3s = 0x%04x (%d)\n", name, val, val)
+	}
+	if sim.Fault != nil {
+		fmt.Fprintf(os.Stderr, "fault: %v\n", sim.Fault)
+	}
 }